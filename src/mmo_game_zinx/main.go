@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"mmo_game_zinx/core"
+	"time"
 	"zinx/ziface"
 	"zinx/znet"
 )
@@ -30,6 +32,28 @@ func main() {
 
 	//注册一些路由业务
 
+	//服务器启动之后,定时打印当前在线的玩家数量
+	s.AddStartupTask(func(ctx context.Context) {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Println("=====> Online players: ", s.GetConnMgr().Len(), " <=====")
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	//服务器关闭时,所有玩家都已经下线,做最后的收尾工作
+	s.AddShutdownTask(func() error {
+		fmt.Println("=====> MMO Game Zinx is shutting down <=====")
+		return nil
+	})
+
 	//启动服务
-	s.Serve()
+	if err := s.Serve(); err != nil {
+		fmt.Println("MMO Game Zinx serve err: ", err)
+	}
 }
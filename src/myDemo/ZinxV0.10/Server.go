@@ -111,9 +111,9 @@ func DoConnectionBegin(conn ziface.IConnection) {
 }
 
 //连接断开之前的需要执行的函数
-func DoConnectionLost(conn ziface.IConnection) {
+func DoConnectionLost(conn ziface.IConnection, closeErr *ziface.CloseError) {
 	fmt.Println("====> DoConnectionList is Called... ")
-	fmt.Println("conn ID = ", conn.GetConnID(), " is Lost ...")
+	fmt.Println("conn ID = ", conn.GetConnID(), " is Lost ... reason: ", closeErr)
 
 	//获取连接属性
 	if name, err := conn.GetProperty("Name"); err == nil {
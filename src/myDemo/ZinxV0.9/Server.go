@@ -56,9 +56,9 @@ func DoConnectionBegin(conn ziface.IConnection) {
 }
 
 //连接断开之前的需要执行的函数
-func DoConnectionLost(conn ziface.IConnection) {
+func DoConnectionLost(conn ziface.IConnection, closeErr *ziface.CloseError) {
 	fmt.Println("====> DoConnectionList is Called... ")
-	fmt.Println("conn ID = ", conn.GetConnID(), " is Lost ...")
+	fmt.Println("conn ID = ", conn.GetConnID(), " is Lost ... reason: ", closeErr)
 }
 
 func main() {
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"zinx/ziface"
 )
 
@@ -16,19 +18,97 @@ type GlobalObj struct {
 	TcpPort   int            //当前服务器主机监听的端口号
 	Name      string         //当前服务器的名称
 
+	Network    string //服务器监听的网络类型: tcp4,tcp6,tcp(IPv4和IPv6双栈)或unix
+	UnixSocket string //Network为unix时监听的socket文件路径
+
 	//Zinx
 	Version          string //当前Zinx的版本号
 	MaxConn          int    //当前服务器主机允许的最大连接数
 	MaxPackageSize   uint32 //当前Zinx框架数据包的最大值
 	WorkerPoolSize   uint32 //当前业务工作Worker池的Goroutine数量
 	MaxWorkerTaskLen uint32 //Zinx框架允许用户最多开辟多少个Worker(限定条件)
+	PanicCloseConn   bool   //处理消息发生panic之后,是否关闭发送该消息的连接
+	SerialConnHandle bool   //WorkerPoolSize为0时,每个连接使用自己的队列按顺序处理消息,否则每个消息开启一个Goroutine
+	MaxConnTaskLen   uint32 //SerialConnHandle时每个连接的队列长度,队列满时阻塞该连接的Reader
+	ShutdownTimeout  int    //Serve收到退出信号之后,等待优雅关闭完成的最长时间(秒),0表示一直等待
+
+	NotFoundPolicy     string //收到没有注册路由的消息时的处理策略,见NotFoundXXX
+	NotFoundMsgID      uint32 //NotFoundReply策略下回复给客户端的消息ID
+	NotFoundMaxStrikes int    //NotFoundDisconnect策略下,一个连接最多允许发送多少个没有注册路由的消息
+
+	TaskQueueOverflow     string //Worker的TaskQueue满时的处理策略,见TaskQueueOverflowXXX
+	TaskQueueBlockTimeout int    //TaskQueueOverflowBlock策略下最长的等待时间(毫秒),超时之后丢弃当前请求,0表示一直等待
+	TaskQueueFullMsgID    uint32 //TaskQueueOverflowReject策略下回复给客户端的消息ID,0表示不回复
+
+	WorkerPoolMinSize uint32 //弹性Worker池最少保持的Worker Goroutine数量,0时按1处理
+	WorkerPoolMaxSize uint32 //弹性Worker池最多的Worker Goroutine数量,0表示关闭弹性伸缩,每个TaskQueue固定一个Worker,大于WorkerPoolSize时按WorkerPoolSize处理
+	WorkerIdleTimeout int    //弹性Worker池中Worker空闲多久之后退出(毫秒),Worker数量不会少于WorkerPoolMinSize
+
+	PriorityStarvationLimit int //低优先级队列有请求时,最多连续处理多少个更高优先级的请求就要处理一个低优先级的请求,0表示严格按优先级处理
+
+	MaxMsgChanLen       uint32 //每个连接SendBuffMsg发送队列的缓冲长度
+	MsgChanOverflow     string //SendBuffMsg发送队列满时的处理策略,见MsgChanOverflowXXX
+	MsgChanBlockTimeout int    //MsgChanOverflowBlock策略下最长的等待时间(毫秒),0表示一直等待
+
+	HeartbeatInterval int    //心跳检测的间隔(秒),0表示不开启心跳检测
+	HeartbeatMsgID    uint32 //心跳消息的MsgID,服务器每次检测时发送给客户端,0表示服务器不主动发送心跳
+	MaxIdleTime       int    //连接最长的空闲时间(秒),超过之后调用OnConnIdle,没有注册OnConnIdle则关闭连接,0表示不检测
+
+	ReadTimeout  int //收到一个消息的第一个字节之后,读完整个消息的最长时间(秒),0表示不限制
+	WriteTimeout int //写一个消息给客户端的最长时间(秒),0表示不限制
+
+	ServerFullMsgID      uint32 //连接数达到MaxConn时,回复给新连接的消息ID,0表示不回复直接关闭
+	ServerFullMsg        string //回复给新连接的提示信息
+	ServerFullRetryAfter int    //建议客户端多久之后重新连接(秒),0表示不提示
+
+	CertFile     string //TLS证书文件的路径,和KeyFile同时配置时开启TLS
+	KeyFile      string //TLS私钥文件的路径
+	ClientCAFile string //校验客户端证书的CA文件路径,配置之后要求客户端提供证书(mTLS)
+
+	WsPort int    //WebSocket服务监听的端口号,0表示不开启WebSocket
+	WsPath string //WebSocket服务的URL路径
+
+	UdpPort           int      //UDP服务监听的端口号,0表示不开启UDP
+	UdpIdleTimeout    int      //UDP会话多久没有收到数据之后过期(秒),0表示不过期
+	UdpReliableMsgIDs []uint32 //通过UDP可靠有序传输的消息ID,其余的消息不保证送达
+	UdpMaxSessions    int      //UDP会话的最大数量,和MaxConn分开限制,避免UDP会话占满TCP和WebSocket的连接,0表示只受MaxConn限制
+
+	CallTimeout int //Call的ctx没有设置超时时,等待回复的最长时间(秒),0表示一直等待
 }
 
+//SendBuffMsg发送队列满时的处理策略
+const (
+	MsgChanOverflowDrop       = "drop"       //丢弃当前消息并返回错误
+	MsgChanOverflowBlock      = "block"      //阻塞等待队列有空位,超时则返回错误
+	MsgChanOverflowDisconnect = "disconnect" //断开当前连接并返回错误
+)
+
+//收到没有注册路由的消息时的处理策略
+const (
+	NotFoundIgnore     = "ignore"     //忽略该消息
+	NotFoundReply      = "reply"      //回复客户端一个NotFoundMsg
+	NotFoundDisconnect = "disconnect" //累计达到NotFoundMaxStrikes之后断开连接
+)
+
+//Worker的TaskQueue满时的处理策略
+const (
+	TaskQueueOverflowBlock      = "block"       //阻塞Reader等待队列有空位
+	TaskQueueOverflowDropNewest = "drop_newest" //丢弃当前的请求
+	TaskQueueOverflowDropOldest = "drop_oldest" //丢弃队列中最早的请求,放入当前的请求
+	TaskQueueOverflowReject     = "reject"      //丢弃当前的请求,并回复客户端一个TaskQueueFullMsg
+)
+
 //定义一个全局的对外对象GlobalObj
 var GlobalObject *GlobalObj
 
 //从zinx.json去加载用于自定义的参数
 func (g *GlobalObj) Reload() {
+	//配置文件不存在时(比如在包目录下运行go test),沿用默认的参数,并提示没有加载配置文件
+	if _, err := os.Stat("conf/zinx.json"); os.IsNotExist(err) {
+		fmt.Printf("[Zinx] WARNING: conf/zinx.json not found, using default config, Host:%s, TcpPort:%d\n", g.Host, g.TcpPort)
+		return
+	}
+
 	data, err := ioutil.ReadFile("conf/zinx.json")
 	//将json文件数据解析到struct中
 	if err != nil {
@@ -48,10 +128,33 @@ func init() {
 		Version:          "V0.9",
 		TcpPort:          8999,
 		Host:             "0.0.0.0",
+		Network:          "tcp4",
 		MaxConn:          1000,
 		MaxPackageSize:   4096,
 		WorkerPoolSize:   10,   //Worker工作池的队列的个数
 		MaxWorkerTaskLen: 1024, //每个worker对应的消息队列的任务的数量最大值
+		MaxConnTaskLen:   64,
+		ShutdownTimeout:  30,
+		MaxMsgChanLen:    1024,
+		MsgChanOverflow:  MsgChanOverflowDrop,
+		ServerFullMsg:    "server is full",
+		WsPath:           "/",
+		UdpIdleTimeout:   30,
+		UdpMaxSessions:   500,
+		CallTimeout:      10,
+
+		//没有注册路由的消息默认忽略
+		NotFoundPolicy:     NotFoundIgnore,
+		NotFoundMaxStrikes: 3,
+
+		//TaskQueue满时默认阻塞Reader
+		TaskQueueOverflow: TaskQueueOverflowBlock,
+
+		//弹性Worker池默认关闭,开启之后空闲10秒的Worker退出
+		WorkerIdleTimeout: 10000,
+
+		//低优先级的请求最多等待16个高优先级的请求
+		PriorityStarvationLimit: 16,
 	}
 
 	//应该尝试从conf/zinx.json去加载一些用户自定义的参数
@@ -1,23 +1,60 @@
 package ziface
 
+import "context"
+
 //定义一个服务器接口
 type IServer interface {
 	//启动服务器
 	Start()
 	//停止服务器
 	Stop()
-	//运行服务器
-	Serve()
+	//优雅关闭服务器:停止接收新连接,等待队列中的请求处理完毕,关闭所有连接
+	//直到所有Goroutine退出或ctx超时才返回
+	Shutdown(ctx context.Context) error
+	//运行服务器,阻塞直到收到SIGINT/SIGTERM信号或者服务器被关闭,返回关闭过程中的错误
+	Serve() error
 	//路由功能:给当前的服务注册一个路由方法,供客户端的连接处理使用
 	AddRouter(msgID uint32, router IRouter)
+	//注册路由,msgID已经注册过时返回错误而不是panic
+	TryAddRouter(msgID uint32, router IRouter) error
+	//注册路由并设置消息的优先级,高优先级的消息在Worker中优先处理
+	AddRouterWithPriority(msgID uint32, router IRouter, priority Priority)
+	//设置消息的优先级,没有设置的消息为PriorityNormal,需要在Start之前调用
+	SetPriority(msgID uint32, priority Priority)
+	//注册一个处理函数作为路由
+	AddHandlerFunc(msgID uint32, handler func(request IRequest))
+	//设置把请求分配给Worker的策略,默认按照ConnID分配
+	SetDispatcher(dispatcher IDispatcher)
+	//设置兜底路由,处理所有没有注册路由的消息
+	SetNotFoundRouter(router IRouter)
+	//创建一个路由分组,分组内路由的msgID必须在[start, end]之内,middlewares只对分组内的路由生效
+	Group(start, end uint32, middlewares ...Middleware) IRouterGroup
+	//添加对所有消息生效的中间件,按照添加的顺序由外到内执行
+	Use(middlewares ...Middleware)
+	//添加只对msgID生效的中间件,在所有全局中间件之后执行
+	UseFor(msgID uint32, middlewares ...Middleware)
 	//获取当前server的连接管理器
 	GetConnMgr() IConnManager
+	//设置当前server所有连接使用的封包拆包模块,需要在Start之前调用
+	SetPacket(packet IDataPack)
+	//获取当前server所有连接使用的封包拆包模块
+	GetPacket() IDataPack
 	//注册OnConnStart钩子函数的方法
 	SetOnConnStart(func(connection IConnection))
-	//注册OnConnStop钩子函数的方法
-	SetOnConnStop(func(connection IConnection))
+	//注册OnConnStop钩子函数的方法,closeErr为连接关闭的原因,没有调用过OnConnStart的连接(TLS握手失败)不会调用
+	SetOnConnStop(func(connection IConnection, closeErr *CloseError))
+	//注册OnConnIdle钩子函数的方法,连接空闲超过MaxIdleTime时,每次心跳检测都会调用
+	SetOnConnIdle(func(connection IConnection))
+	//注册处理消息发生panic时调用的Hook函数
+	SetOnPanic(func(request IRequest, recovered interface{}, stack []byte))
 	//调用OnConnStart钩子函数的方法
 	CallOnConnStart(connection IConnection)
-	//调用OnConnStop钩子函数的方法
+	//调用OnConnStop钩子函数的方法,关闭原因取自connection.GetCloseError()
 	CallOnConnStop(connection IConnection)
+	//调用OnConnIdle钩子函数的方法,没有注册OnConnIdle则直接停止连接
+	CallOnConnIdle(connection IConnection)
+	//添加服务器启动之后执行的任务,每个任务运行在独立的Goroutine中,服务器关闭时ctx会被取消
+	AddStartupTask(task func(ctx context.Context))
+	//添加服务器关闭时执行的任务,在所有连接和Worker停止之后按添加的顺序执行
+	AddShutdownTask(task func() error)
 }
@@ -0,0 +1,31 @@
+package ziface
+
+import "context"
+
+//定义一个客户端接口
+type IClient interface {
+	//启动客户端,在后台连接服务器,断开之后按照退避策略自动重连
+	Start()
+	//停止客户端,断开当前连接并且不再重连
+	Stop()
+	//路由功能:给当前的客户端注册一个路由方法,处理服务器发送过来的消息
+	AddRouter(msgID uint32, router IRouter)
+	//注册一个处理函数作为路由
+	AddHandlerFunc(msgID uint32, handler func(request IRequest))
+	//添加对服务器所有消息生效的中间件,按照添加的顺序由外到内执行
+	Use(middlewares ...Middleware)
+	//添加只对msgID生效的中间件,在所有全局中间件之后执行
+	UseFor(msgID uint32, middlewares ...Middleware)
+	//发送消息给服务器,没有连接上服务器时返回错误
+	SendMsg(msgID uint32, data []byte) error
+	//RPC调用服务器,等待服务器通过IRequest.Reply回复,需要使用ISeqDataPack的封包格式
+	Call(ctx context.Context, msgID uint32, data []byte) (IMessage, error)
+	//获取当前和服务器的连接,没有连接上服务器时返回nil
+	GetConnection() IConnection
+	//获取当前客户端使用的封包拆包模块
+	GetPacket() IDataPack
+	//注册OnConnStart钩子函数的方法,每次连接上服务器(包括重连)都会调用
+	SetOnConnStart(func(connection IConnection))
+	//注册OnConnStop钩子函数的方法,closeErr为连接断开的原因
+	SetOnConnStop(func(connection IConnection, closeErr *CloseError))
+}
@@ -0,0 +1,57 @@
+package ziface
+
+import "fmt"
+
+//连接关闭的原因
+type CloseReason int
+
+const (
+	CloseReasonUnknown        CloseReason = iota //未知原因
+	CloseReasonEOF                               //客户端主动断开了连接
+	CloseReasonTimeout                           //读写超时或者空闲超时
+	CloseReasonProtocolError                     //客户端发送的数据不符合协议,比如数据包过大
+	CloseReasonNetError                          //网络错误,比如连接被重置
+	CloseReasonServerShutdown                    //服务器关闭
+	CloseReasonKicked                            //被服务端主动断开,比如业务上踢下线
+	CloseReasonPanic                             //处理该连接的消息时发生了panic
+)
+
+//连接关闭原因的名称
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonEOF:
+		return "EOF"
+	case CloseReasonTimeout:
+		return "timeout"
+	case CloseReasonProtocolError:
+		return "protocol error"
+	case CloseReasonNetError:
+		return "net error"
+	case CloseReasonServerShutdown:
+		return "server shutdown"
+	case CloseReasonKicked:
+		return "kicked"
+	case CloseReasonPanic:
+		return "panic"
+	default:
+		return "unknown"
+	}
+}
+
+//连接关闭的原因,以及导致连接关闭的具体错误
+type CloseError struct {
+	Reason CloseReason //关闭的原因分类
+	Err    error       //导致关闭的具体错误,可以为nil
+}
+
+func (e *CloseError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("connection closed: %s", e.Reason)
+	}
+	return fmt.Sprintf("connection closed: %s: %v", e.Reason, e.Err)
+}
+
+//返回导致关闭的具体错误,支持errors.Is/errors.As
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
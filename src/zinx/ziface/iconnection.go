@@ -1,21 +1,46 @@
 package ziface
 
-import "net"
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
 
 //定义连接模块的抽象层
 type IConnection interface {
 	//启动连接 让当前的连接准备开始工作
 	Start()
-	//停止连接 结束当前连接的工作
+	//停止连接 结束当前连接的工作,多次调用只会执行一次,关闭原因为CloseReasonKicked
 	Stop()
-	//获取当前连接的绑定socket conn
+	//以指定的原因停止连接,err为导致关闭的具体错误,可以为nil
+	StopWithReason(reason CloseReason, err error)
+	//当前连接是否已经关闭
+	IsClosed() bool
+	//获取当前连接关闭的原因,连接未关闭时返回nil
+	GetCloseError() *CloseError
+	//获取当前连接的绑定socket conn,不是TCP连接(比如TLS,Unix socket连接)时返回nil
+	//Deprecated: 使用和传输方式无关的GetConn
 	GetTCPConnection() *net.TCPConn
+	//获取当前连接底层的net.Conn,TLS连接返回*tls.Conn,Unix socket连接返回*net.UnixConn
+	GetConn() net.Conn
+	//获取TLS连接的状态(包含客户端的证书信息),不是TLS连接时返回nil
+	GetTLSConnectionState() *tls.ConnectionState
 	//获取当前连接模块的连接ID
 	GetConnID() uint32
 	//获取远程客户端的 TCP状态 IP Port
 	RemoteAddr() net.Addr
+	//获取当前连接最后一次收到客户端消息的时间
+	GetLastActivity() time.Time
 	//发送数据,将数据发送给远程的客户端
 	SendMsg(msgId uint32, data []byte) error
+	//发送数据,将数据放入带缓冲的发送队列,队列满时按照配置的MsgChanOverflow策略处理
+	SendBuffMsg(msgId uint32, data []byte) error
+	//发送携带seqID的数据,连接的封包格式无法携带seqID时返回错误
+	SendSeqMsg(msgId uint32, seqID uint32, data []byte) error
+	//RPC调用:分配一个seqID发送请求,等待对端通过IRequest.Reply回复
+	//ctx没有设置超时时使用配置的CallTimeout,需要连接使用ISeqDataPack的封包格式
+	Call(ctx context.Context, msgId uint32, data []byte) (IMessage, error)
 
 	//设置连接属性
 	SetProperty(key string, value interface{})
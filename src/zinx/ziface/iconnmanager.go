@@ -10,6 +10,8 @@ type IConnManager interface {
 	Get(connID uint32) (IConnection, error)
 	//得到当前连接总数
 	Len() int
+	//遍历当前所有的连接,fn在锁外执行,可以在fn中停止连接
+	Range(fn func(conn IConnection))
 	//清除并终止所有的连接
 	ClearConn()
 }
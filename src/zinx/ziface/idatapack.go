@@ -2,6 +2,7 @@ package ziface
 
 //封包,拆包 模块
 //直接面向TCP连接中的数据流,用于处理TCP粘包问题
+//同一个IDataPack会被所有连接并发使用,实现时需要保证并发安全
 type IDataPack interface {
 	//获取包的头的长度方法
 	GetHeadLen() uint32
@@ -9,4 +10,12 @@ type IDataPack interface {
 	Pack(msg IMessage) ([]byte, error)
 	//拆包方法
 	Unpack([]byte)(IMessage, error)
-}
\ No newline at end of file
+}
+
+//可以在包头中携带seqID的封包拆包模块
+//IConnection.Call需要连接使用这样的封包格式,否则无法把回复和请求对应起来
+type ISeqDataPack interface {
+	IDataPack
+	//包头中是否携带了IMessage的seqID
+	HasSeqID() bool
+}
@@ -0,0 +1,12 @@
+package ziface
+
+import "bufio"
+
+//流式拆包模块
+//直接从连接带缓冲的数据流中解析出一个完整的消息,适用于varint长度前缀,TLV等
+//无法用固定的GetHeadLen()来描述包头的封包格式
+//如果Server配置的IDataPack同时实现了IDecoder,连接会使用Decode进行拆包
+type IDecoder interface {
+	//从数据流中读取一个完整的消息(包含消息的内容)
+	Decode(r *bufio.Reader) (IMessage, error)
+}
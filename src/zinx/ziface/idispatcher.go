@@ -0,0 +1,15 @@
+package ziface
+
+//把请求分配给Worker的策略
+type IDispatcher interface {
+	//返回处理request的WorkerID,queues提供Worker的数量和每个Worker等待处理的请求数
+	Dispatch(request IRequest, queues IWorkerQueues) uint32
+}
+
+//Worker队列的负载信息
+type IWorkerQueues interface {
+	//Worker的数量
+	Len() int
+	//Worker所有优先级的队列中等待处理的请求数
+	QueueLen(workerID uint32) int
+}
@@ -8,6 +8,8 @@ type IMessage interface {
 	GetMsgLen() uint32
 	//获取消息的内容
 	GetData() []byte
+	//获取消息的seqID,用来把RPC的回复和请求对应起来,封包格式不携带seqID时为0
+	GetSeqID() uint32
 
 	//设置消息的ID
 	SetMsgId(uint32)
@@ -15,4 +17,6 @@ type IMessage interface {
 	SetData([]byte)
 	//设置消息的长度
 	SetDataLen(uint32)
+	//设置消息的seqID
+	SetSeqID(uint32)
 }
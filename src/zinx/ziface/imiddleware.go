@@ -0,0 +1,8 @@
+package ziface
+
+//消息的处理函数,返回的错误可以被外层的中间件观察到
+type HandlerFunc func(request IRequest) error
+
+//中间件,包装next得到新的处理函数
+//可以在调用next前后加入鉴权,日志,统计耗时等逻辑,不调用next则中断后续的处理
+type Middleware func(next HandlerFunc) HandlerFunc
@@ -2,12 +2,48 @@ package ziface
 
 //消息管理抽象层
 type IMsgHandle interface {
-	//调度/执行对应的Router消息处理方法
+	//调度/执行对应的Router消息处理方法,处理过程中的panic会被恢复,不会影响Worker
 	DoMsgHandler(request IRequest)
-	//为消息添加具体的处理逻辑
+	//注册处理消息发生panic时调用的Hook函数,recovered为recover()的返回值,stack为panic时的调用栈
+	SetOnPanic(func(request IRequest, recovered interface{}, stack []byte))
+	//为消息添加具体的处理逻辑,msgID已经注册过时panic
 	AddRouter(msgID uint32, router IRouter)
+	//为消息添加具体的处理逻辑,msgID已经注册过时返回错误
+	TryAddRouter(msgID uint32, router IRouter) error
+	//为消息添加具体的处理逻辑,并设置消息的优先级
+	AddRouterWithPriority(msgID uint32, router IRouter, priority Priority)
+	//设置消息的优先级,没有设置的消息为PriorityNormal,需要在启动Worker工作池之前调用
+	SetPriority(msgID uint32, priority Priority)
+	//为消息添加一个处理函数,不需要定义Router结构体
+	AddHandlerFunc(msgID uint32, handler func(request IRequest))
+	//设置兜底路由,处理所有没有注册路由的消息,之后仍然按照配置的NotFoundPolicy处理
+	SetNotFoundRouter(router IRouter)
+	//创建一个路由分组,分组内路由的msgID必须在[start, end]之内,middlewares只对分组内的路由生效
+	Group(start, end uint32, middlewares ...Middleware) IRouterGroup
+	//添加对所有消息生效的中间件,按照添加的顺序由外到内执行
+	Use(middlewares ...Middleware)
+	//添加只对msgID生效的中间件,在所有全局中间件之后,按照添加的顺序由外到内执行
+	UseFor(msgID uint32, middlewares ...Middleware)
 	//启动Worker工作池
 	StartWorkerPool()
+	//停止Worker工作池,等待各TaskQueue中剩余的请求处理完毕之后返回
+	StopWorkerPool()
 	//将消息发送给消息任务队列处理
 	SendMsgToTaskQueue(request IRequest)
+	//设置把请求分配给Worker的策略,默认按照ConnID分配
+	SetDispatcher(dispatcher IDispatcher)
+	//获取每个Worker的统计数据,开启弹性Worker池时每项对应一个TaskQueue
+	GetWorkerStats() []WorkerStats
+	//获取当前运行的Worker Goroutine数量,开启弹性Worker池时在WorkerPoolMinSize和WorkerPoolMaxSize之间变化
+	GetWorkerNum() int
+}
+
+//一个Worker的统计数据
+type WorkerStats struct {
+	WorkerID   uint32 //Worker的ID
+	QueueLen   int    //TaskQueue中等待处理的请求数
+	Dispatched uint64 //放入TaskQueue的请求数
+	Processed  uint64 //已经处理完的请求数
+	Dropped    uint64 //TaskQueue满时被丢弃的请求数(drop_newest,drop_oldest以及block超时)
+	Rejected   uint64 //TaskQueue满时被拒绝并回复客户端的请求数
 }
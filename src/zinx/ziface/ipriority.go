@@ -0,0 +1,10 @@
+package ziface
+
+//消息的优先级,每个Worker为不同优先级的消息使用不同的队列,优先处理高优先级队列中的请求
+type Priority int
+
+const (
+	PriorityHigh   Priority = iota //登录,心跳等需要尽快处理的消息
+	PriorityNormal                 //没有设置优先级的消息
+	PriorityLow                    //聊天等大量且可以延后处理的消息
+)
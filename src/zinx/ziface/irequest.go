@@ -9,4 +9,8 @@ type IRequest interface {
 	GetData() []byte
 	//得到请求的消息ID
 	GetMsgID() uint32
+	//得到请求的seqID,不是通过Call发送的请求为0
+	GetSeqID() uint32
+	//回复对端的Call,回复的消息使用请求的msgID和seqID,请求不是Call或者封包格式无法携带seqID时返回错误
+	Reply(data []byte) error
 }
@@ -0,0 +1,15 @@
+package ziface
+
+//路由分组,按照模块组织一段连续的msgID,分组内的路由共用分组的中间件
+type IRouterGroup interface {
+	//添加只对分组内的路由生效的中间件,在全局中间件之后执行
+	Use(middlewares ...Middleware)
+	//在分组内注册路由,msgID不在分组范围内或者已经注册过时panic
+	AddRouter(msgID uint32, router IRouter)
+	//在分组内注册路由,msgID不在分组范围内或者已经注册过时返回错误
+	TryAddRouter(msgID uint32, router IRouter) error
+	//在分组内注册一个处理函数作为路由
+	AddHandlerFunc(msgID uint32, handler func(request IRequest))
+	//创建一个子分组,范围必须在当前分组之内,子分组的路由先经过当前分组的中间件
+	Group(start, end uint32, middlewares ...Middleware) IRouterGroup
+}
@@ -0,0 +1,314 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/ziface"
+)
+
+const (
+	//连接服务器的超时时间
+	clientDialTimeout = 5 * time.Second
+	//默认的断线重连退避时间
+	defaultReconnectMin = time.Second
+	defaultReconnectMax = 30 * time.Second
+)
+
+//还没有连接上服务器,或者连接已经断开正在重连
+var ErrClientNotConnected = errors.New("client not connected")
+
+//IClient的接口实现,连接服务器之后和Server的连接一样使用Connection读写消息,用IMsgHandle处理服务器的消息
+type Client struct {
+	//客户端的名称
+	Name string
+	//服务器的地址
+	Address string
+	//当前Client的消息管理模块,用来绑定MsgID和对应的处理业务API的关系
+	MsgHandler ziface.IMsgHandle
+	//当前Client的连接管理器,最多只有一个连接
+	ConnMgr ziface.IConnManager
+	//该Client使用的封包拆包模块
+	packet ziface.IDataPack
+	//连接服务器的方法
+	dial func() (net.Conn, error)
+	//连接上服务器之后自动调用Hook函数--OnConnStart
+	OnConnStart func(conn ziface.IConnection)
+	//连接断开之前自动调用Hook函数--OnConnStop
+	OnConnStop func(conn ziface.IConnection, closeErr *ziface.CloseError)
+
+	//断线重连的退避时间
+	reconnectMin time.Duration
+	reconnectMax time.Duration
+	//心跳的间隔和消息ID
+	heartbeatInterval time.Duration
+	heartbeatMsgID    uint32
+
+	//当前和服务器的连接
+	conn     *Connection
+	connLock sync.Mutex
+	//下一个连接的ID,每次重连加一,只能通过atomic读写
+	nextConnID uint32
+	//告知Client已经停止的channel
+	exitChan chan struct{}
+	stopOnce sync.Once
+	//追踪连接服务器的Goroutine
+	wg sync.WaitGroup
+}
+
+//初始化Client模块的方法
+func NewClient(name string, address string, opts ...ClientOption) ziface.IClient {
+	c := &Client{
+		Name:         name,
+		Address:      address,
+		MsgHandler:   NewMsgHandle(),
+		ConnMgr:      NewConnManager(),
+		packet:       NewDataPack(),
+		reconnectMin: defaultReconnectMin,
+		reconnectMax: defaultReconnectMax,
+		exitChan:     make(chan struct{}),
+	}
+	c.dial = func() (net.Conn, error) {
+		return net.DialTimeout("tcp", c.Address, clientDialTimeout)
+	}
+
+	//应用开发者传入的自定义选项
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+//启动客户端
+func (c *Client) Start() {
+	fmt.Printf("[Zinx] Client Name :%s, connect to %s\n", c.Name, c.Address)
+
+	//开启消息队列及Worker工作池
+	c.MsgHandler.StartWorkerPool()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run()
+	}()
+}
+
+//连接服务器,连接断开之后按照退避策略重连,直到Client停止
+func (c *Client) run() {
+	delay := c.reconnectMin
+	for {
+		conn, err := c.dial()
+		if err != nil {
+			fmt.Println("[Zinx Client] ", c.Name, " dial err: ", err)
+			if !c.waitReconnect(&delay) {
+				return
+			}
+			continue
+		}
+		cid := atomic.AddUint32(&c.nextConnID, 1) - 1
+		dealConn := newConnection(c, conn, cid, c.MsgHandler)
+		//如果在连接的过程中Client已经停止,则直接关闭连接
+		if !c.setConn(dealConn) {
+			dealConn.StopWithReason(ziface.CloseReasonKicked, nil)
+			return
+		}
+
+		connected := time.Now()
+		dealConn.Start()
+		c.keepAlive(dealConn)
+		dealConn.wait()
+		c.setConn(nil)
+
+		//连接上之后马上被断开(服务器已满或者不断重启)仍然按照失败退避,连接稳定之后才重新从reconnectMin开始
+		if time.Since(connected) >= c.stableTime() {
+			delay = c.reconnectMin
+		}
+
+		if !c.waitReconnect(&delay) {
+			return
+		}
+	}
+}
+
+//等待下一次重连,不重连或者Client已经停止时返回false
+func (c *Client) waitReconnect(delay *time.Duration) bool {
+	if c.reconnectMin <= 0 {
+		return false
+	}
+
+	//在[delay/2, delay]之间随机等待,避免大量客户端在服务器恢复时同时重连
+	wait := *delay/2 + time.Duration(rand.Int63n(int64(*delay/2)+1))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.exitChan:
+		return false
+	}
+
+	//每次失败退避时间翻倍
+	*delay *= 2
+	if c.reconnectMax > 0 && *delay > c.reconnectMax {
+		*delay = c.reconnectMax
+	}
+	return true
+}
+
+//连接保持多久之后算作稳定
+func (c *Client) stableTime() time.Duration {
+	if c.reconnectMax > 0 {
+		return c.reconnectMax
+	}
+	return defaultReconnectMax
+}
+
+//连接断开之前定时发送心跳
+func (c *Client) keepAlive(conn *Connection) {
+	if c.heartbeatInterval <= 0 || c.heartbeatMsgID == 0 {
+		<-conn.ExitChan
+		return
+	}
+
+	ticker := time.NewTicker(c.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.SendBuffMsg(c.heartbeatMsgID, nil); err != nil {
+				fmt.Println("[Zinx Client] ", c.Name, " send heartbeat err: ", err)
+			}
+		case <-conn.ExitChan:
+			return
+		}
+	}
+}
+
+//记录当前的连接,如果Client已经停止则返回false
+func (c *Client) setConn(conn *Connection) bool {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
+	if conn != nil && c.isStopped() {
+		return false
+	}
+	c.conn = conn
+	return true
+}
+
+//Client是否已经停止
+func (c *Client) isStopped() bool {
+	select {
+	case <-c.exitChan:
+		return true
+	default:
+		return false
+	}
+}
+
+//停止客户端,等待连接的Goroutine退出以及TaskQueue中剩余的消息处理完毕
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		fmt.Println("[STOP] Zinx client name ", c.Name)
+
+		c.connLock.Lock()
+		close(c.exitChan)
+		conn := c.conn
+		c.connLock.Unlock()
+
+		if conn != nil {
+			conn.Stop()
+		}
+		c.wg.Wait()
+
+		c.MsgHandler.StopWorkerPool()
+	})
+}
+
+//路由功能:给当前的客户端注册一个路由方法,处理服务器发送过来的消息
+func (c *Client) AddRouter(msgID uint32, router ziface.IRouter) {
+	c.MsgHandler.AddRouter(msgID, router)
+}
+
+//注册一个处理函数作为路由
+func (c *Client) AddHandlerFunc(msgID uint32, handler func(request ziface.IRequest)) {
+	c.MsgHandler.AddHandlerFunc(msgID, handler)
+}
+
+//添加对服务器所有消息生效的中间件
+func (c *Client) Use(middlewares ...ziface.Middleware) {
+	c.MsgHandler.Use(middlewares...)
+}
+
+//添加只对msgID生效的中间件
+func (c *Client) UseFor(msgID uint32, middlewares ...ziface.Middleware) {
+	c.MsgHandler.UseFor(msgID, middlewares...)
+}
+
+//发送消息给服务器
+func (c *Client) SendMsg(msgID uint32, data []byte) error {
+	conn := c.GetConnection()
+	if conn == nil {
+		return ErrClientNotConnected
+	}
+	return conn.SendMsg(msgID, data)
+}
+
+//RPC调用服务器
+func (c *Client) Call(ctx context.Context, msgID uint32, data []byte) (ziface.IMessage, error) {
+	conn := c.GetConnection()
+	if conn == nil {
+		return nil, ErrClientNotConnected
+	}
+	return conn.Call(ctx, msgID, data)
+}
+
+//获取当前和服务器的连接
+func (c *Client) GetConnection() ziface.IConnection {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
+	//避免返回一个值为nil的接口
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn
+}
+
+func (c *Client) GetConnMgr() ziface.IConnManager {
+	return c.ConnMgr
+}
+
+//获取当前客户端使用的封包拆包模块
+func (c *Client) GetPacket() ziface.IDataPack {
+	return c.packet
+}
+
+//注册OnConnStart钩子函数的方法
+func (c *Client) SetOnConnStart(hookFunc func(connection ziface.IConnection)) {
+	c.OnConnStart = hookFunc
+}
+
+//注册OnConnStop钩子函数的方法
+func (c *Client) SetOnConnStop(hookFunc func(connection ziface.IConnection, closeErr *ziface.CloseError)) {
+	c.OnConnStop = hookFunc
+}
+
+//调用OnConnStart钩子函数的方法
+func (c *Client) CallOnConnStart(conn ziface.IConnection) {
+	if c.OnConnStart != nil {
+		c.OnConnStart(conn)
+	}
+}
+
+//调用OnConnStop钩子函数的方法
+func (c *Client) CallOnConnStop(conn ziface.IConnection) {
+	if c.OnConnStop != nil {
+		c.OnConnStop(conn, conn.GetCloseError())
+	}
+}
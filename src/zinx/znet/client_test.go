@@ -0,0 +1,134 @@
+package znet
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+//记录收到的消息
+type recordRouter struct {
+	BaseRouter
+	msgs chan string
+}
+
+func (r *recordRouter) Handle(request ziface.IRequest) {
+	r.msgs <- string(request.GetData())
+}
+
+//统计收到的消息个数
+type countRouter struct {
+	BaseRouter
+	count int32
+}
+
+func (r *countRouter) Handle(request ziface.IRequest) {
+	atomic.AddInt32(&r.count, 1)
+}
+
+func TestClient(t *testing.T) {
+	s := newTestServer(t, 7791)
+	s.AddRouter(1, &echoRouter{})
+	heartbeats := &countRouter{}
+	s.AddRouter(99, heartbeats)
+	s.Start()
+	defer s.Stop()
+
+	c := NewClient("[zinx test client]", "127.0.0.1:7791",
+		WithReconnect(20*time.Millisecond, 100*time.Millisecond),
+		WithClientHeartbeat(20*time.Millisecond, 99))
+	router := &recordRouter{msgs: make(chan string, 10)}
+	c.AddRouter(1, router)
+	started := make(chan ziface.IConnection, 10)
+	c.SetOnConnStart(func(conn ziface.IConnection) {
+		started <- conn
+	})
+	c.Start()
+	defer c.Stop()
+
+	//连接上服务器之后,服务器的回复交给客户端的路由处理
+	for round := 0; round < 2; round++ {
+		select {
+		case <-started:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("round %d: client not connected", round)
+		}
+		if err := c.SendMsg(1, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-router.msgs:
+			if msg != "hello" {
+				t.Errorf("recv %q, want %q", msg, "hello")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("round %d: no reply from server", round)
+		}
+
+		//服务器踢掉连接之后客户端自动重连
+		s.GetConnMgr().Range(func(conn ziface.IConnection) {
+			conn.Stop()
+		})
+	}
+
+	//重连之后继续发送心跳
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("client not reconnected")
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&heartbeats.count) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&heartbeats.count) == 0 {
+		t.Error("server received no heartbeat")
+	}
+
+	c.Stop()
+	if err := c.SendMsg(1, nil); err != ErrClientNotConnected {
+		t.Errorf("SendMsg after Stop err = %v, want %v", err, ErrClientNotConnected)
+	}
+}
+
+//服务器接受连接之后马上关闭(比如服务器已满),客户端的重连间隔仍然逐渐变长
+func TestClientReconnectBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:7803")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan time.Time, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			select {
+			case accepted <- time.Now():
+			default:
+			}
+		}
+	}()
+
+	c := NewClient("[zinx test client]", "127.0.0.1:7803", WithReconnect(20*time.Millisecond, time.Second))
+	c.Start()
+	defer c.Stop()
+
+	var times []time.Time
+	for len(times) < 5 {
+		select {
+		case at := <-accepted:
+			times = append(times, at)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("client reconnected %d times, want 5", len(times))
+		}
+	}
+	//退避时间依次为20ms,40ms,80ms,160ms,随机等待其中的一半到全部
+	if gap := times[4].Sub(times[3]); gap < 80*time.Millisecond {
+		t.Errorf("4th reconnect delay = %v, want at least 80ms", gap)
+	}
+}
@@ -1,34 +1,72 @@
 package znet
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 	"zinx/utils"
 	"zinx/ziface"
 )
 
+var (
+	//连接已经关闭,无法再发送消息
+	ErrConnClosed = errors.New("connection closed")
+	//客户端没有在ReadTimeout内发送完一个消息
+	ErrReadTimeout = errors.New("read msg timeout")
+	//没有在WriteTimeout内将一个消息写给客户端
+	ErrWriteTimeout = errors.New("write msg timeout")
+	//连接空闲的时间超过了MaxIdleTime
+	ErrIdleTimeout = errors.New("connection idle timeout")
+	//SendBuffMsg发送队列已满,消息被丢弃
+	ErrMsgBuffFull = errors.New("msg buff chan is full, msg dropped")
+	//SendBuffMsg等待发送队列超时,消息被丢弃
+	ErrMsgBuffTimeout = errors.New("msg buff chan is full, wait timeout")
+	//SendBuffMsg发送队列已满,连接已经被断开
+	ErrMsgBuffDisconnect = errors.New("msg buff chan is full, connection stopped")
+	//连接的封包格式无法携带seqID,不能使用Call
+	ErrSeqNotSupported = errors.New("packet does not carry seq id")
+)
+
+//连接所属的Server或者Client,提供封包格式,连接管理和连接的Hook函数
+type connHost interface {
+	GetConnMgr() ziface.IConnManager
+	GetPacket() ziface.IDataPack
+	CallOnConnStart(conn ziface.IConnection)
+	CallOnConnStop(conn ziface.IConnection)
+}
+
 //连接模块
 type Connection struct {
-	//当前Conn隶属于哪个Server
+	//当前Conn隶属于哪个Server,Client的连接为nil
 	TcpServer ziface.IServer
+	//当前Conn隶属的Server或者Client
+	host connHost
 
-	//当前连接的socket TCP套接字
-	Conn *net.TCPConn
+	//当前连接的socket套接字,TCP连接或者TLS连接
+	Conn net.Conn
 
 	//连接的ID
 	ConnID uint32
 
-	//当前的连接状态
-	isClosed bool
+	//当前的连接状态,0为正常,1为已关闭,只能通过atomic读写
+	isClosed int32
+	//连接是否已经开始工作并调用过OnConnStart,只能通过atomic读写
+	started int32
 
-	//告知当前连接已经退出/停止的channel(由Reader告知Writer退出)
+	//告知当前连接已经退出/停止的channel,连接关闭时会被close,Writer和正在发送的消息都会收到通知
 	ExitChan chan bool
 
 	//无缓冲的管道,用于读/写Goroutine之间的消息通信
 	msgChan chan []byte
+	//有缓冲的管道,用于读/写Goroutine之间的消息通信
+	msgBuffChan chan []byte
 
 	//消息的管理MsgID和对应的处理业务API关系
 	MsgHandler ziface.IMsgHandle
@@ -37,23 +75,66 @@ type Connection struct {
 	property map[string]interface{}
 	//保护连接属性的锁
 	propertyLock sync.RWMutex
+
+	//追踪当前连接开启的读写及业务Goroutine
+	wg sync.WaitGroup
+
+	//最后一次收到客户端消息的时间(UnixNano),只能通过atomic读写
+	lastActivity int64
+
+	//连接关闭的原因,存放*ziface.CloseError
+	closeErr atomic.Value
+
+	//正在等待回复的Call,key为seqID
+	calls    map[uint32]chan ziface.IMessage
+	callLock sync.Mutex
+	//下一个Call的seqID,只能通过atomic读写
+	nextSeqID uint32
+
+	//没有开启工作池并且开启了SerialConnHandle时,当前连接自己的消息队列,只能在Reader中创建和关闭
+	taskQueue chan ziface.IRequest
+	//没有开启工作池时,追踪处理当前连接消息的Goroutine
+	handlerWg sync.WaitGroup
+
+	//关闭服务器时停止Reader,连接仍然保持打开以便发送剩余的回复,只能通过atomic读写
+	readStopped int32
+	//Reader退出时close
+	readerDone chan struct{}
+	//关闭服务器时close,通知Writer写完发送队列中剩余的消息之后退出
+	flushChan chan struct{}
+	flushOnce sync.Once
+	//Writer退出时close
+	writerDone chan struct{}
 }
 
 //初始化连接模块的方法
-func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandle) *Connection {
+func NewConnection(server ziface.IServer, conn net.Conn, connID uint32, msgHandler ziface.IMsgHandle) *Connection {
+	c := newConnection(server, conn, connID, msgHandler)
+	c.TcpServer = server
+	return c
+}
+
+//初始化Server或者Client的连接
+func newConnection(host connHost, conn net.Conn, connID uint32, msgHandler ziface.IMsgHandle) *Connection {
 	c := &Connection{
-		TcpServer:  server,
-		Conn:       conn,
-		ConnID:     connID,
-		MsgHandler: msgHandler,
-		isClosed:   false,
-		msgChan:    make(chan []byte),
-		ExitChan:   make(chan bool, 1),
-		property:   make(map[string]interface{}),
+		host:        host,
+		Conn:        conn,
+		ConnID:      connID,
+		MsgHandler:  msgHandler,
+		isClosed:    0,
+		msgChan:     make(chan []byte),
+		msgBuffChan: make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
+		ExitChan:    make(chan bool),
+		property:    make(map[string]interface{}),
+		calls:       make(map[uint32]chan ziface.IMessage),
+		readerDone:  make(chan struct{}),
+		flushChan:   make(chan struct{}),
+		writerDone:  make(chan struct{}),
 	}
+	c.updateActivity()
 
 	//将conn加入到ConnManager中
-	c.TcpServer.GetConnMgr().Add(c)
+	c.host.GetConnMgr().Add(c)
 
 	return c
 }
@@ -61,36 +142,46 @@ func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgH
 func (c *Connection) StartReader() {
 	fmt.Println("[Reader Goroutine is running]")
 	defer fmt.Println("[Reader is exit!],connID = ", c.ConnID, ", remote addr is ", c.RemoteAddr().String())
-	defer c.Stop()
+
+	//获取Server配置的拆包解包的对象
+	dp := c.host.GetPacket()
+	//带缓冲的读取连接中的数据流,减少系统调用
+	reader := bufio.NewReader(c.Conn)
+	defer close(c.readerDone)
+	//Reader是连接队列唯一的生产者,退出时关闭队列,处理完剩余的消息之后处理的Goroutine退出
+	defer c.closeTaskQueue()
 
 	for {
-		//创建一个拆包解包的对象
-		dp := NewDataPack()
-
-		//读取客户端的Msg Head 二进制流8个字节,
-		headData := make([]byte, dp.GetHeadLen())
-		if _, err := io.ReadFull(c.GetTCPConnection(), headData); err != nil {
-			fmt.Println("read msg head error", err)
-			break
+		//关闭服务器时不再读取新的消息
+		if c.isReadStopped() {
+			return
 		}
 
-		//拆包,得到MsgID和MsgDatalen放在msg消息中
-		msg, err := dp.Unpack(headData)
+		//从数据流中拆出一个完整的消息,包含MsgID,MsgDatalen和Data
+		msg, err := c.readFrame(reader, dp)
 		if err != nil {
-			fmt.Println("unpack error", err)
-			break
+			//被stopReading打断的读取不关闭连接,连接留给Writer发送剩余的回复
+			if c.isReadStopped() {
+				return
+			}
+			fmt.Println("read msg error", err)
+			c.StopWithReason(closeReasonOf(err), err)
+			return
 		}
 
-		//根据dataLen再次读取Data,放在Msg.Data中
-		var data []byte
-		if msg.GetMsgLen() > 0 {
-			data = make([]byte, msg.GetMsgLen())
-			if _, err := io.ReadFull(c.GetTCPConnection(), data); err != nil {
-				fmt.Println("read msg data error", err)
-				break
-			}
+		//收到了客户端的消息,刷新连接的活跃时间
+		c.updateActivity()
+
+		//客户端回复的心跳消息只用来刷新活跃时间,不需要交给路由处理
+		if utils.GlobalObject.HeartbeatMsgID != 0 && msg.GetMsgId() == utils.GlobalObject.HeartbeatMsgID {
+			continue
+		}
+
+		//Call的回复直接交给等待的Call,不需要交给路由处理
+		if msg.GetSeqID()&SeqReplyFlag != 0 {
+			c.resolveCall(msg)
+			continue
 		}
-		msg.SetData(data)
 
 		//得到当前conn数据的Request请求数据
 		req := Request{
@@ -101,81 +192,342 @@ func (c *Connection) StartReader() {
 		if utils.GlobalObject.WorkerPoolSize > 0 {
 			//已经开启了工作池机制,将消息发送给Worker工作池处理即可
 			c.MsgHandler.SendMsgToTaskQueue(&req)
+		} else if utils.GlobalObject.SerialConnHandle {
+			//交给当前连接自己的队列,按照收到的顺序依次处理
+			if !c.sendToTaskQueue(&req) {
+				return
+			}
 		} else {
 			//从路由中,找到注册绑定的Conn对应的router调用
 			//根据绑定好的MsgID找到对应处理api业务执行
-			go c.MsgHandler.DoMsgHandler(&req)
+			c.handlerWg.Add(1)
+			go func() {
+				defer c.handlerWg.Done()
+				c.MsgHandler.DoMsgHandler(&req)
+			}()
 		}
 
 	}
 }
 
+//将消息放入当前连接的队列,第一次调用时才创建队列和处理的Goroutine
+//队列满时只阻塞当前连接的Reader,连接关闭时返回false
+func (c *Connection) sendToTaskQueue(request ziface.IRequest) bool {
+	if c.taskQueue == nil {
+		c.taskQueue = make(chan ziface.IRequest, utils.GlobalObject.MaxConnTaskLen)
+		c.handlerWg.Add(1)
+		go c.startTaskWorker(c.taskQueue)
+	}
+
+	select {
+	case c.taskQueue <- request:
+		return true
+	case <-c.ExitChan:
+		return false
+	}
+}
+
+//依次处理当前连接队列中的消息,直到队列被关闭
+func (c *Connection) startTaskWorker(taskQueue chan ziface.IRequest) {
+	defer c.handlerWg.Done()
+
+	for request := range taskQueue {
+		c.MsgHandler.DoMsgHandler(request)
+	}
+}
+
+//关闭当前连接的队列,只能由Reader调用
+func (c *Connection) closeTaskQueue() {
+	if c.taskQueue != nil {
+		close(c.taskQueue)
+	}
+}
+
 //写消息
 func (c *Connection) StartWriter() {
 	fmt.Println("[Writer Goroutine is running]")
 	defer fmt.Println("[conn Writer exit!]", c.RemoteAddr().String())
+	defer close(c.writerDone)
 
 	//不断的阻塞的等待channel的消息, 进行写给客户端
 	for {
 		select {
 		case data := <-c.msgChan:
 			//有数据要写给客户端
-			if _, err := c.Conn.Write(data); err != nil {
+			if err := c.writeFrame(data); err != nil {
 				fmt.Println("Send data error, ", err)
+				c.StopWithReason(closeReasonOf(err), err)
 				return
 			}
+		case data := <-c.msgBuffChan:
+			//有缓冲队列中的数据要写给客户端
+			if err := c.writeFrame(data); err != nil {
+				fmt.Println("Send buff data error, ", err)
+				c.StopWithReason(closeReasonOf(err), err)
+				return
+			}
+		case <-c.flushChan:
+			//服务器正在关闭,写完剩余的消息之后退出
+			c.flushWriter()
+			return
 		case <-c.ExitChan:
-			//代表Reader已经退出,此时Writer也要退出
+			//代表连接已经关闭,此时Writer也要退出
+			return
+		}
+
+	}
+}
+
+//把发送队列中剩余的消息以及正在等待Writer的消息写给客户端,写失败时放弃剩余的消息
+func (c *Connection) flushWriter() {
+	for {
+		var data []byte
+		select {
+		case data = <-c.msgChan:
+		case data = <-c.msgBuffChan:
+		default:
+			return
+		}
+		if err := c.writeFrame(data); err != nil {
+			fmt.Println("Flush data error, ", err)
 			return
 		}
+	}
+}
+
+//停止Reader读取新的消息,正在阻塞的读取会立即返回,连接不会被关闭
+func (c *Connection) stopReading() {
+	atomic.StoreInt32(&c.readStopped, 1)
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+//Reader是否已经被stopReading停止
+func (c *Connection) isReadStopped() bool {
+	return atomic.LoadInt32(&c.readStopped) == 1
+}
+
+//等待Reader退出,连接已经关闭时直接返回
+func (c *Connection) waitReader() {
+	select {
+	case <-c.readerDone:
+	case <-c.ExitChan:
+	}
+}
+
+//通知Writer写完剩余的消息之后退出,返回的channel在Writer退出时被close
+func (c *Connection) flush() <-chan struct{} {
+	c.flushOnce.Do(func() {
+		close(c.flushChan)
+	})
+	return c.writerDone
+}
+
+//Writer是否已经退出,服务器关闭时Writer会在连接被关闭之前退出,之后的消息不会再被写给客户端
+func (c *Connection) isWriterDone() bool {
+	select {
+	case <-c.writerDone:
+		return true
+	default:
+		return false
+	}
+}
+
+//设置读取的截止时间,Reader已经被停止时立即超时,避免覆盖stopReading设置的截止时间
+func (c *Connection) setReadDeadline(t time.Time) {
+	c.Conn.SetReadDeadline(t)
+	if c.isReadStopped() {
+		c.Conn.SetReadDeadline(time.Now())
+	}
+}
+
+//读取一个完整的消息
+//等待消息的第一个字节时不设置超时(空闲的连接由心跳检测处理),收到第一个字节之后整个消息需要在ReadTimeout内读完
+func (c *Connection) readFrame(reader *bufio.Reader, dp ziface.IDataPack) (ziface.IMessage, error) {
+	if _, err := reader.Peek(1); err != nil {
+		return nil, err
+	}
+
+	if utils.GlobalObject.ReadTimeout > 0 {
+		c.setReadDeadline(time.Now().Add(time.Duration(utils.GlobalObject.ReadTimeout) * time.Second))
+		defer c.setReadDeadline(time.Time{})
+	}
+
+	msg, err := readMessage(reader, dp)
+	if isTimeout(err) {
+		return nil, ErrReadTimeout
+	}
+	return msg, err
+}
+
+//将一个消息写给客户端,需要在WriteTimeout内写完
+func (c *Connection) writeFrame(data []byte) error {
+	if utils.GlobalObject.WriteTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Duration(utils.GlobalObject.WriteTimeout) * time.Second))
+	}
+
+	if _, err := c.Conn.Write(data); err != nil {
+		if isTimeout(err) {
+			return ErrWriteTimeout
+		}
+		return err
+	}
+	return nil
+}
+
+//判断是否为读写超时的错误
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
 
+//根据读写连接时的错误得到连接关闭的原因
+func closeReasonOf(err error) ziface.CloseReason {
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return ziface.CloseReasonEOF
+	case err == ErrReadTimeout || err == ErrWriteTimeout || isTimeout(err):
+		return ziface.CloseReasonTimeout
 	}
+	if _, ok := err.(net.Error); ok {
+		return ziface.CloseReasonNetError
+	}
+	//其余的错误都来自拆包,说明客户端发送的数据不符合协议
+	return ziface.CloseReasonProtocolError
 }
 
 //启动连接 让当前的连接准备开始工作
 func (c *Connection) Start() {
 	fmt.Println("Conn Start().. ConnID = ", c.ConnID)
+
+	//TLS连接需要先完成握手,之后才能拿到客户端的证书信息
+	if err := c.handshake(); err != nil {
+		fmt.Println("tls handshake error, ConnID = ", c.ConnID, " err: ", err)
+		c.StopWithReason(ziface.CloseReasonProtocolError, err)
+		return
+	}
+	atomic.StoreInt32(&c.started, 1)
+	c.wg.Add(2)
 	//启动从当前连接读数据的业务
-	go c.StartReader()
+	go func() {
+		defer c.wg.Done()
+		c.StartReader()
+	}()
 	//启动从当前连接写数据的业务
-	go c.StartWriter()
+	go func() {
+		defer c.wg.Done()
+		c.StartWriter()
+	}()
 
 	//按照开发者传递进来的 创建链接之后需要调用的处理业务,执行对应的Hook函数
-	c.TcpServer.CallOnConnStart(c)
+	c.host.CallOnConnStart(c)
 }
 
 //停止连接 结束当前连接的工作
 func (c *Connection) Stop() {
-	fmt.Println("Conn Stop().. ConnID = ", c.ConnID)
+	c.StopWithReason(ziface.CloseReasonKicked, nil)
+}
 
-	//如果当前连接已经关闭
-	if c.isClosed == true {
+//以指定的原因停止连接
+func (c *Connection) StopWithReason(reason ziface.CloseReason, err error) {
+	//如果当前连接已经关闭,保证关闭的流程只执行一次
+	if !atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
 		return
 	}
-	c.isClosed = true
 
-	//调用开发者注册的 销毁连接之前 需要执行的业务Hook函数
-	c.TcpServer.CallOnConnStop(c)
+	//记录关闭的原因,OnConnStop中可以获取
+	closeErr := &ziface.CloseError{Reason: reason, Err: err}
+	c.closeErr.Store(closeErr)
+	fmt.Println("Conn Stop().. ConnID = ", c.ConnID, " ", closeErr)
+
+	//调用开发者注册的 销毁连接之前 需要执行的业务Hook函数,OnConnStart没有调用过(比如TLS握手失败)时不调用
+	if atomic.LoadInt32(&c.started) == 1 {
+		c.host.CallOnConnStop(c)
+	}
 
 	//关闭socket连接
 	c.Conn.Close()
 
-	//告知Writer关闭
-	c.ExitChan <- true
+	//告知Writer以及所有阻塞在发送消息上的Goroutine,连接已经关闭
+	//msgChan和msgBuffChan不做close,避免其他Goroutine向已关闭的channel发送消息引起panic
+	close(c.ExitChan)
 
 	//将当前连接从ConnMgr摘除掉
-	c.TcpServer.GetConnMgr().Remove(c)
+	c.host.GetConnMgr().Remove(c)
+}
 
-	//回收资源
-	close(c.ExitChan)
-	close(c.msgChan)
+//当前连接是否已经关闭
+func (c *Connection) IsClosed() bool {
+	return atomic.LoadInt32(&c.isClosed) == 1
+}
+
+//获取当前连接关闭的原因
+func (c *Connection) GetCloseError() *ziface.CloseError {
+	closeErr, _ := c.closeErr.Load().(*ziface.CloseError)
+	return closeErr
+}
+
+//获取当前连接最后一次收到客户端消息的时间
+func (c *Connection) GetLastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+}
+
+//刷新当前连接的活跃时间
+func (c *Connection) updateActivity() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
 }
 
-//获取当前连接的绑定socket conn
+//阻塞等待当前连接开启的所有Goroutine退出
+func (c *Connection) wait() {
+	c.wg.Wait()
+	c.handlerWg.Wait()
+}
+
+//TLS握手的最长时间
+const tlsHandshakeTimeout = 10 * time.Second
+
+//如果是TLS连接,在限定的时间内完成握手
+func (c *Connection) handshake() error {
+	tlsConn, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer tlsConn.SetDeadline(time.Time{})
+	//握手期间服务器开始关闭时不再等待握手
+	if c.isReadStopped() {
+		tlsConn.SetReadDeadline(time.Now())
+	}
+
+	return tlsConn.Handshake()
+}
+
+//获取当前连接的绑定socket conn,不是TCP连接时返回nil
+//Deprecated: 使用GetConn
 func (c *Connection) GetTCPConnection() *net.TCPConn {
+	tcpConn, _ := c.Conn.(*net.TCPConn)
+	return tcpConn
+}
+
+//获取当前连接底层的net.Conn
+func (c *Connection) GetConn() net.Conn {
 	return c.Conn
 }
 
+//获取TLS连接的状态,不是TLS连接时返回nil
+func (c *Connection) GetTLSConnectionState() *tls.ConnectionState {
+	switch conn := c.Conn.(type) {
+	case *tls.Conn:
+		state := conn.ConnectionState()
+		return &state
+	case *wsConn:
+		//通过HTTPS升级的WebSocket连接
+		return conn.tlsConnectionState()
+	default:
+		return nil
+	}
+}
+
 //获取当前连接模块的连接ID
 func (c *Connection) GetConnID() uint32 {
 	return c.ConnID
@@ -188,26 +540,205 @@ func (c *Connection) RemoteAddr() net.Addr {
 
 //提供一个SendMsg方法 将我们要发送给客户端的数据,先进行封包,在发送
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
-	if c.isClosed == true {
-		return errors.New("Connection closed when send msg")
+	if c.IsClosed() {
+		return ErrConnClosed
 	}
 
-	//将data进行封包 MsgDataLen|MsgID|Data
-	dp := NewDataPack()
+	return c.sendMsg(NewMsgPackage(msgId, data))
+}
+
+//发送携带seqID的数据,封包格式无法携带seqID时返回ErrSeqNotSupported,避免seqID被丢弃之后对端当作普通消息处理
+func (c *Connection) SendSeqMsg(msgId uint32, seqID uint32, data []byte) error {
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	if !c.seqSupported() {
+		return ErrSeqNotSupported
+	}
+
+	msg := NewMsgPackage(msgId, data)
+	msg.SetSeqID(seqID)
+	return c.sendMsg(msg)
+}
+
+//将消息封包之后交给Writer发送
+func (c *Connection) sendMsg(msg ziface.IMessage) error {
+	//使用Server配置的封包对象将data进行封包,默认为 MsgDataLen|MsgID|Data
+	dp := c.host.GetPacket()
+
+	binaryMsg, err := dp.Pack(msg)
+	if err != nil {
+		fmt.Println("Pack error msg id = ", msg.GetMsgId())
+		return errors.New("Pack error msg")
+	}
+
+	//将数据发送给客户端,如果在等待Writer的过程中连接被关闭或者Writer已经退出,则直接返回
+	select {
+	case c.msgChan <- binaryMsg:
+		return nil
+	case <-c.ExitChan:
+		return ErrConnClosed
+	case <-c.writerDone:
+		return ErrConnClosed
+	}
+}
+
+//RPC调用,发送请求之后等待对端的回复,回复通过seqID和请求对应
+func (c *Connection) Call(ctx context.Context, msgId uint32, data []byte) (ziface.IMessage, error) {
+	if !c.seqSupported() {
+		return nil, ErrSeqNotSupported
+	}
+
+	//ctx没有设置超时时,使用配置的默认超时
+	if _, ok := ctx.Deadline(); !ok && utils.GlobalObject.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(utils.GlobalObject.CallTimeout)*time.Second)
+		defer cancel()
+	}
+
+	//seqID的最高位用来标记回复,分配的seqID不能为0也不能带有最高位
+	seqID := atomic.AddUint32(&c.nextSeqID, 1) &^ SeqReplyFlag
+	if seqID == 0 {
+		seqID = atomic.AddUint32(&c.nextSeqID, 1) &^ SeqReplyFlag
+	}
+
+	replyChan := make(chan ziface.IMessage, 1)
+	c.callLock.Lock()
+	c.calls[seqID] = replyChan
+	c.callLock.Unlock()
+	defer func() {
+		c.callLock.Lock()
+		delete(c.calls, seqID)
+		c.callLock.Unlock()
+	}()
+
+	if err := c.SendSeqMsg(msgId, seqID, data); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ExitChan:
+		return nil, ErrConnClosed
+	}
+}
+
+//连接的封包格式是否可以携带seqID
+func (c *Connection) seqSupported() bool {
+	dp, ok := c.host.GetPacket().(ziface.ISeqDataPack)
+	return ok && dp.HasSeqID()
+}
+
+//把收到的回复交给等待的Call,Call已经超时返回时丢弃回复
+func (c *Connection) resolveCall(msg ziface.IMessage) {
+	seqID := msg.GetSeqID() &^ SeqReplyFlag
+
+	c.callLock.Lock()
+	replyChan, ok := c.calls[seqID]
+	c.callLock.Unlock()
+
+	if !ok {
+		fmt.Println("drop reply of unknown call, ConnID = ", c.ConnID, " seqID = ", seqID)
+		return
+	}
+	msg.SetSeqID(seqID)
+	//对端重复回复同一个Call时只保留第一个回复
+	select {
+	case replyChan <- msg:
+	default:
+	}
+}
+
+//提供一个SendBuffMsg方法 将数据封包之后放入带缓冲的发送队列,不会等待Writer写完
+func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
+	if c.IsClosed() || c.isWriterDone() {
+		return ErrConnClosed
+	}
+
+	//使用Server配置的封包对象将data进行封包
+	dp := c.host.GetPacket()
 
-	//MsgDataLen|MsgId|Data
 	binaryMsg, err := dp.Pack(NewMsgPackage(msgId, data))
 	if err != nil {
 		fmt.Println("Pack error msg id = ", msgId)
 		return errors.New("Pack error msg")
 	}
 
-	//将数据发送给客户端
-	c.msgChan <- binaryMsg
+	//先尝试直接放入发送队列
+	if c.tryPushBuff(binaryMsg) {
+		return nil
+	}
 
+	//发送队列已满,按照配置的策略处理
+	switch utils.GlobalObject.MsgChanOverflow {
+	case utils.MsgChanOverflowBlock:
+		//timeout为nil时一直等待
+		var timeout <-chan time.Time
+		if utils.GlobalObject.MsgChanBlockTimeout > 0 {
+			timer := time.NewTimer(time.Duration(utils.GlobalObject.MsgChanBlockTimeout) * time.Millisecond)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case c.msgBuffChan <- binaryMsg:
+			return nil
+		case <-c.ExitChan:
+			return ErrConnClosed
+		case <-c.writerDone:
+			return ErrConnClosed
+		case <-timeout:
+			fmt.Println("SendBuffMsg timeout, ConnID = ", c.ConnID, " msg id = ", msgId)
+			return ErrMsgBuffTimeout
+		}
+	case utils.MsgChanOverflowDisconnect:
+		fmt.Println("SendBuffMsg chan full, stop ConnID = ", c.ConnID)
+		c.StopWithReason(ziface.CloseReasonKicked, ErrMsgBuffDisconnect)
+		return ErrMsgBuffDisconnect
+	default:
+		fmt.Println("SendBuffMsg chan full, drop msg id = ", msgId, " ConnID = ", c.ConnID)
+		return ErrMsgBuffFull
+	}
+}
+
+//不论MsgChanOverflow配置了什么策略,都以非阻塞的方式把消息放入带缓冲的发送队列,队列满时丢弃消息
+//用于心跳检测,Reader等不能被一个慢连接阻塞的地方
+func (c *Connection) trySendBuffMsg(msgId uint32, data []byte) error {
+	if c.IsClosed() || c.isWriterDone() {
+		return ErrConnClosed
+	}
+
+	binaryMsg, err := c.host.GetPacket().Pack(NewMsgPackage(msgId, data))
+	if err != nil {
+		fmt.Println("Pack error msg id = ", msgId)
+		return errors.New("Pack error msg")
+	}
+	if !c.tryPushBuff(binaryMsg) {
+		return ErrMsgBuffFull
+	}
 	return nil
 }
 
+//非阻塞地把封包之后的消息放入带缓冲的发送队列
+func (c *Connection) tryPushBuff(binaryMsg []byte) bool {
+	select {
+	case c.msgBuffChan <- binaryMsg:
+		return true
+	default:
+		return false
+	}
+}
+
+//非阻塞地给conn发送消息,不是*Connection的连接只能使用SendBuffMsg
+func trySendBuffMsg(conn ziface.IConnection, msgId uint32, data []byte) error {
+	if c, ok := conn.(*Connection); ok {
+		return c.trySendBuffMsg(msgId, data)
+	}
+	return conn.SendBuffMsg(msgId, data)
+}
+
 //设置连接属性
 func (c *Connection) SetProperty(key string, value interface{}) {
 	c.propertyLock.Lock()
@@ -0,0 +1,288 @@
+package znet
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+func TestSendBuffMsgOverflow(t *testing.T) {
+	oldLen, oldPolicy, oldTimeout := utils.GlobalObject.MaxMsgChanLen, utils.GlobalObject.MsgChanOverflow, utils.GlobalObject.MsgChanBlockTimeout
+	defer func() {
+		utils.GlobalObject.MaxMsgChanLen = oldLen
+		utils.GlobalObject.MsgChanOverflow = oldPolicy
+		utils.GlobalObject.MsgChanBlockTimeout = oldTimeout
+	}()
+	utils.GlobalObject.MaxMsgChanLen = 1
+	utils.GlobalObject.MsgChanBlockTimeout = 20
+
+	tests := []struct {
+		policy string
+		err    error
+	}{
+		{utils.MsgChanOverflowDrop, ErrMsgBuffFull},
+		{utils.MsgChanOverflowBlock, ErrMsgBuffTimeout},
+	}
+	for _, tt := range tests {
+		utils.GlobalObject.MsgChanOverflow = tt.policy
+
+		//没有启动Writer,发送队列不会被消费
+		s := NewServer("[zinx test]")
+		c := NewConnection(s, nil, 1, s.(*Server).MsgHandler)
+
+		if err := c.SendBuffMsg(1, []byte("zinx")); err != nil {
+			t.Fatalf("%s: first SendBuffMsg err: %v", tt.policy, err)
+		}
+		start := time.Now()
+		if err := c.SendBuffMsg(1, []byte("zinx")); err != tt.err {
+			t.Errorf("%s: SendBuffMsg err = %v, want %v", tt.policy, err, tt.err)
+		}
+		if tt.policy == utils.MsgChanOverflowBlock && time.Since(start) < 20*time.Millisecond {
+			t.Errorf("%s: SendBuffMsg returned before timeout", tt.policy)
+		}
+	}
+}
+
+//建立一对本地TCP连接,返回服务端的连接和客户端的连接
+func newTCPConnPair(t *testing.T) (*net.TCPConn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn.(*net.TCPConn), client
+}
+
+//并发的发送消息和关闭连接,不能出现panic和data race,关闭之后发送消息返回ErrConnClosed
+func TestConnectionConcurrentSendStop(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		s := NewServer("[zinx test]")
+		var stopped int32
+		s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+			atomic.AddInt32(&stopped, 1)
+		})
+
+		serverConn, client := newTCPConnPair(t)
+		//客户端不断读取并丢弃数据
+		go io.Copy(ioutil.Discard, client)
+
+		c := NewConnection(s, serverConn, uint32(round), s.(*Server).MsgHandler)
+		c.Start()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if err := c.SendMsg(1, []byte("zinx")); err != nil && err != ErrConnClosed {
+						t.Errorf("SendMsg err: %v", err)
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					err := c.SendBuffMsg(1, []byte("zinx"))
+					if err != nil && err != ErrConnClosed && err != ErrMsgBuffFull {
+						t.Errorf("SendBuffMsg err: %v", err)
+					}
+				}
+			}()
+		}
+		//Reader(对端关闭)和其他Goroutine同时关闭连接
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Stop()
+			}()
+		}
+		client.Close()
+		wg.Wait()
+		c.wait()
+
+		if !c.IsClosed() {
+			t.Fatal("connection not closed after Stop")
+		}
+		if n := atomic.LoadInt32(&stopped); n != 1 {
+			t.Fatalf("OnConnStop called %d times, want 1", n)
+		}
+		if err := c.SendMsg(1, []byte("zinx")); err != ErrConnClosed {
+			t.Fatalf("SendMsg after Stop err = %v, want %v", err, ErrConnClosed)
+		}
+		if err := c.SendBuffMsg(1, []byte("zinx")); err != ErrConnClosed {
+			t.Fatalf("SendBuffMsg after Stop err = %v, want %v", err, ErrConnClosed)
+		}
+	}
+}
+
+//客户端只发送了半个包头,超过ReadTimeout之后连接被关闭,而空闲的连接不受ReadTimeout影响
+func TestConnectionReadTimeout(t *testing.T) {
+	oldTimeout := utils.GlobalObject.ReadTimeout
+	defer func() {
+		utils.GlobalObject.ReadTimeout = oldTimeout
+	}()
+	utils.GlobalObject.ReadTimeout = 1
+
+	s := NewServer("[zinx test]").(*Server)
+
+	stallConn, stallClient := newTCPConnPair(t)
+	defer stallClient.Close()
+	stall := NewConnection(s, stallConn, 1, s.MsgHandler)
+	stall.Start()
+
+	idleConn, idleClient := newTCPConnPair(t)
+	defer idleClient.Close()
+	idle := NewConnection(s, idleConn, 2, s.MsgHandler)
+	idle.Start()
+
+	stallClient.Write([]byte{4, 0, 0})
+	time.Sleep(1500 * time.Millisecond)
+
+	if !stall.IsClosed() {
+		t.Fatal("stalled connection not stopped after ReadTimeout")
+	}
+	stall.wait()
+	if closeErr := stall.GetCloseError(); closeErr.Reason != ziface.CloseReasonTimeout || closeErr.Err != ErrReadTimeout {
+		t.Errorf("close err = %v, want %v", closeErr, ErrReadTimeout)
+	}
+	if idle.IsClosed() {
+		t.Error("idle connection stopped by ReadTimeout")
+	}
+	idle.Stop()
+}
+
+//连接关闭的原因会传给OnConnStop
+func TestConnectionCloseReason(t *testing.T) {
+	oldSize := utils.GlobalObject.MaxPackageSize
+	defer func() {
+		utils.GlobalObject.MaxPackageSize = oldSize
+	}()
+	utils.GlobalObject.MaxPackageSize = 16
+
+	dp := NewDataPack()
+	bigMsg, _ := dp.Pack(NewMsgPackage(1, make([]byte, 32)))
+
+	tests := []struct {
+		name   string
+		client func(conn net.Conn)
+		reason ziface.CloseReason
+	}{
+		{"eof", func(conn net.Conn) { conn.Close() }, ziface.CloseReasonEOF},
+		{"too large", func(conn net.Conn) { conn.Write(bigMsg) }, ziface.CloseReasonProtocolError},
+	}
+	for _, tt := range tests {
+		s := NewServer("[zinx test]").(*Server)
+		hookErr := make(chan *ziface.CloseError, 1)
+		s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+			hookErr <- closeErr
+		})
+
+		serverConn, client := newTCPConnPair(t)
+		c := NewConnection(s, serverConn, 1, s.MsgHandler)
+		c.Start()
+		tt.client(client)
+
+		select {
+		case closeErr := <-hookErr:
+			if closeErr.Reason != tt.reason {
+				t.Errorf("%s: close reason = %v, want %v", tt.name, closeErr.Reason, tt.reason)
+			}
+			if c.GetCloseError() != closeErr {
+				t.Errorf("%s: GetCloseError = %v, want %v", tt.name, c.GetCloseError(), closeErr)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: OnConnStop not called", tt.name)
+		}
+		client.Close()
+		c.wait()
+	}
+
+	//服务端主动断开连接
+	s := NewServer("[zinx test]").(*Server)
+	serverConn, client := newTCPConnPair(t)
+	defer client.Close()
+	c := NewConnection(s, serverConn, 1, s.MsgHandler)
+	if c.GetCloseError() != nil {
+		t.Errorf("GetCloseError = %v before Stop, want nil", c.GetCloseError())
+	}
+	c.Start()
+	c.Stop()
+	if reason := c.GetCloseError().Reason; reason != ziface.CloseReasonKicked {
+		t.Errorf("close reason = %v, want %v", reason, ziface.CloseReasonKicked)
+	}
+}
+
+//记录消息处理的顺序,并检查同一时间只有一个消息在处理
+type orderRouter struct {
+	BaseRouter
+	active     int32
+	concurrent int32
+	order      chan byte
+}
+
+func (r *orderRouter) Handle(request ziface.IRequest) {
+	if atomic.AddInt32(&r.active, 1) > 1 {
+		atomic.StoreInt32(&r.concurrent, 1)
+	}
+	time.Sleep(time.Millisecond)
+	r.order <- request.GetData()[0]
+	atomic.AddInt32(&r.active, -1)
+}
+
+func TestSerialConnHandle(t *testing.T) {
+	defer func(size uint32, serial bool) {
+		utils.GlobalObject.WorkerPoolSize = size
+		utils.GlobalObject.SerialConnHandle = serial
+	}(utils.GlobalObject.WorkerPoolSize, utils.GlobalObject.SerialConnHandle)
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.SerialConnHandle = true
+
+	s := newTestServer(t, 7795)
+	router := &orderRouter{order: make(chan byte, 50)}
+	s.AddRouter(1, router)
+	s.Start()
+	defer s.Stop()
+
+	conn := dialTestServer(t, 7795)
+	defer conn.Close()
+	dp := NewDataPack()
+	var stream []byte
+	for i := 0; i < 50; i++ {
+		data, _ := dp.Pack(NewMsgPackage(1, []byte{byte(i)}))
+		stream = append(stream, data...)
+	}
+	if _, err := conn.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case got := <-router.order:
+			if got != byte(i) {
+				t.Fatalf("handled msg %d at position %d", got, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("msg %d not handled", i)
+		}
+	}
+	if atomic.LoadInt32(&router.concurrent) != 0 {
+		t.Error("msgs of one connection handled concurrently")
+	}
+}
@@ -28,7 +28,7 @@ func (connMgr *ConnManager) Add(conn ziface.IConnection) {
 
 	//将conn加入到ConnManager中
 	connMgr.connections[conn.GetConnID()] = conn
-	fmt.Println("connectionID = ", conn.GetConnID(), " add to ConnManager successfully: conn num = ", connMgr.Len())
+	fmt.Println("connectionID = ", conn.GetConnID(), " add to ConnManager successfully: conn num = ", len(connMgr.connections))
 }
 
 //删除连接
@@ -39,7 +39,7 @@ func (connMgr *ConnManager) Remove(conn ziface.IConnection) {
 
 	//删除链接信息
 	delete(connMgr.connections, conn.GetConnID())
-	fmt.Println("connectionID = ", conn.GetConnID(), " remove from ConnManager successfully: conn num = ", connMgr.Len())
+	fmt.Println("connectionID = ", conn.GetConnID(), " remove from ConnManager successfully: conn num = ", len(connMgr.connections))
 }
 
 //根据connID获取连接
@@ -58,22 +58,43 @@ func (connMgr *ConnManager) Get(connID uint32) (ziface.IConnection, error) {
 
 //得到当前连接总数
 func (connMgr *ConnManager) Len() int {
+	connMgr.connLock.RLock()
+	defer connMgr.connLock.RUnlock()
+
 	return len(connMgr.connections)
 }
 
+//遍历当前所有的连接
+func (connMgr *ConnManager) Range(fn func(conn ziface.IConnection)) {
+	//保护共享资源map,加读锁,先复制一份连接的快照
+	connMgr.connLock.RLock()
+	conns := make([]ziface.IConnection, 0, len(connMgr.connections))
+	for _, conn := range connMgr.connections {
+		conns = append(conns, conn)
+	}
+	connMgr.connLock.RUnlock()
+
+	for _, conn := range conns {
+		fn(conn)
+	}
+}
+
 //清除并终止所有的连接
 func (connMgr *ConnManager) ClearConn() {
-	//保护共享资源map,加写锁
+	//保护共享资源map,加写锁,先把所有的conn摘除下来
 	connMgr.connLock.Lock()
-	defer connMgr.connLock.Unlock()
-
-	//删除conn并停止conn的工作
+	conns := make([]ziface.IConnection, 0, len(connMgr.connections))
 	for connID, conn := range connMgr.connections {
-		//停止
-		conn.Stop()
+		conns = append(conns, conn)
 		//删除
 		delete(connMgr.connections, connID)
 	}
+	connMgr.connLock.Unlock()
+
+	//在锁外停止conn的工作,因为conn.Stop()中会再次调用Remove
+	for _, conn := range conns {
+		conn.StopWithReason(ziface.CloseReasonServerShutdown, nil)
+	}
 
 	fmt.Println("Clear All connections succ! conn num = ", connMgr.Len())
 }
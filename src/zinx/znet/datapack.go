@@ -1,9 +1,11 @@
 package znet
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 	"zinx/utils"
 	"zinx/ziface"
 )
@@ -69,3 +71,58 @@ func (dp *DataPack) Unpack(binaryData []byte) (ziface.IMessage, error) {
 
 	return msg, nil
 }
+
+//流式拆包方法,直接从数据流中读出一个完整的消息
+//包头通过Peek读取,不需要为每个消息单独开辟head的空间
+func (dp *DataPack) Decode(r *bufio.Reader) (ziface.IMessage, error) {
+	headData, err := r.Peek(int(dp.GetHeadLen()))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := dp.Unpack(headData)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Discard(len(headData)); err != nil {
+		return nil, err
+	}
+
+	//根据dataLen再读取Data
+	if msg.GetMsgLen() > 0 {
+		data := make([]byte, msg.GetMsgLen())
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		msg.SetData(data)
+	}
+
+	return msg, nil
+}
+
+//从数据流中读取一个完整的消息
+//dp实现了IDecoder则直接使用Decode,否则先按照GetHeadLen读取head,再根据head中的dataLen读取data
+func readMessage(r *bufio.Reader, dp ziface.IDataPack) (ziface.IMessage, error) {
+	if decoder, ok := dp.(ziface.IDecoder); ok {
+		return decoder.Decode(r)
+	}
+
+	headData := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(r, headData); err != nil {
+		return nil, err
+	}
+	msg, err := dp.Unpack(headData)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if msg.GetMsgLen() > 0 {
+		data = make([]byte, msg.GetMsgLen())
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+	}
+	msg.SetData(data)
+
+	return msg, nil
+}
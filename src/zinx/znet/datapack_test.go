@@ -1,10 +1,14 @@
 package znet
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"testing"
+	"time"
+	"zinx/ziface"
 )
 
 //只是负责测试datapack拆包封包的单元测试
@@ -101,6 +105,48 @@ func TestDataPack(t *testing.T) {
 	//一次性发给服务端
 	conn.Write(sendData1)
 
-	//客户端阻塞
-	select{}
+	//客户端阻塞,等待服务端把两个包拆解完毕
+	time.Sleep(1 * time.Second)
+}
+
+//测试流式拆包,多个消息粘在一起时能够依次被拆出来
+func TestDecode(t *testing.T) {
+	packs := map[string]ziface.IDataPack{
+		"DataPack":       NewDataPack(),
+		"VarintDataPack": NewVarintDataPack(),
+		"SeqDataPack":    NewSeqDataPack(),
+		"shortHeadPack":  &shortHeadPack{}, //没有实现IDecoder,按照GetHeadLen拆包
+	}
+
+	for name, dp := range packs {
+		msgs := []*Message{
+			NewMsgPackage(1, []byte("zinx")),
+			NewMsgPackage(300, nil),
+			NewMsgPackage(2, bytes.Repeat([]byte{'a'}, 200)),
+		}
+
+		var stream []byte
+		for _, msg := range msgs {
+			data, err := dp.Pack(msg)
+			if err != nil {
+				t.Fatalf("%s pack err: %v", name, err)
+			}
+			stream = append(stream, data...)
+		}
+
+		r := bufio.NewReader(bytes.NewReader(stream))
+		for _, want := range msgs {
+			msg, err := readMessage(r, dp)
+			if err != nil {
+				t.Fatalf("%s decode err: %v", name, err)
+			}
+			if msg.GetMsgId() != want.Id || !bytes.Equal(msg.GetData(), want.Data) {
+				t.Errorf("%s decode msgID = %d, len = %d, want %d, %d",
+					name, msg.GetMsgId(), len(msg.GetData()), want.Id, len(want.Data))
+			}
+		}
+		if _, err := readMessage(r, dp); err != io.EOF {
+			t.Errorf("%s decode at end err = %v, want EOF", name, err)
+		}
+	}
 }
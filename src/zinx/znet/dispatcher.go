@@ -0,0 +1,59 @@
+package znet
+
+import (
+	"sync/atomic"
+	"zinx/ziface"
+)
+
+//按照ConnID分配Worker,同一个连接的请求总是由同一个Worker按顺序处理(默认策略)
+type ConnDispatcher struct {
+}
+
+func (d *ConnDispatcher) Dispatch(request ziface.IRequest, queues ziface.IWorkerQueues) uint32 {
+	return request.GetConnection().GetConnID() % uint32(queues.Len())
+}
+
+//轮流分配Worker,不保证同一个连接的请求按顺序处理
+type RoundRobinDispatcher struct {
+	//下一个Worker,只能通过atomic读写
+	next uint32
+}
+
+func (d *RoundRobinDispatcher) Dispatch(request ziface.IRequest, queues ziface.IWorkerQueues) uint32 {
+	return (atomic.AddUint32(&d.next, 1) - 1) % uint32(queues.Len())
+}
+
+//分配给等待处理的请求最少的Worker,不保证同一个连接的请求按顺序处理
+type LeastLoadedDispatcher struct {
+	//每次从不同的Worker开始比较,队列长度相同时不会总是选中第一个Worker,只能通过atomic读写
+	next uint32
+}
+
+func (d *LeastLoadedDispatcher) Dispatch(request ziface.IRequest, queues ziface.IWorkerQueues) uint32 {
+	n := uint32(queues.Len())
+	start := (atomic.AddUint32(&d.next, 1) - 1) % n
+
+	workerID := start
+	for i := uint32(1); i < n; i++ {
+		id := (start + i) % n
+		if queues.QueueLen(id) < queues.QueueLen(workerID) {
+			workerID = id
+		}
+	}
+	return workerID
+}
+
+//按照业务的key分配Worker,比如场景ID或者公会ID
+//key相同的请求总是由同一个Worker按顺序处理,即使它们来自不同的连接
+type KeyDispatcher struct {
+	Key func(request ziface.IRequest) uint64
+}
+
+//创建一个按照key分配Worker的策略
+func NewKeyDispatcher(key func(request ziface.IRequest) uint64) *KeyDispatcher {
+	return &KeyDispatcher{Key: key}
+}
+
+func (d *KeyDispatcher) Dispatch(request ziface.IRequest, queues ziface.IWorkerQueues) uint32 {
+	return uint32(d.Key(request) % uint64(queues.Len()))
+}
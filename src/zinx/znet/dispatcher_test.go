@@ -0,0 +1,69 @@
+package znet
+
+import (
+	"testing"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+func TestDispatcher(t *testing.T) {
+	defer func(size uint32) {
+		utils.GlobalObject.WorkerPoolSize = size
+	}(utils.GlobalObject.WorkerPoolSize)
+	utils.GlobalObject.WorkerPoolSize = 4
+
+	const highMsgID = 2
+	s := NewServer("[zinx test]").(*Server)
+	mh := s.MsgHandler.(*MsgHandle)
+	mh.SetPriority(highMsgID, ziface.PriorityHigh)
+	for i := range mh.TaskQueue {
+		mh.TaskQueue[i] = make(chan ziface.IRequest, 10)
+	}
+	mh.startPriorityQueues()
+	taskQueues := workerQueues{mh}
+	newRequest := func(connID uint32, data string) ziface.IRequest {
+		return &Request{
+			conn: NewConnection(s, nil, connID, s.MsgHandler),
+			msg:  NewMsgPackage(1, []byte(data)),
+		}
+	}
+
+	conn := &ConnDispatcher{}
+	if id := conn.Dispatch(newRequest(6, ""), taskQueues); id != 2 {
+		t.Errorf("ConnDispatcher workerID = %d, want 2", id)
+	}
+
+	rr := &RoundRobinDispatcher{}
+	for i := uint32(0); i < 8; i++ {
+		if id := rr.Dispatch(newRequest(1, ""), taskQueues); id != i%4 {
+			t.Errorf("RoundRobinDispatcher round %d workerID = %d, want %d", i, id, i%4)
+		}
+	}
+
+	//Worker 2的TaskQueue为空,但是高优先级队列中有更多的请求,Worker 3等待处理的请求最少
+	for i, n := range []int{3, 2, 0, 1} {
+		for j := 0; j < n; j++ {
+			mh.enqueue(uint32(i), newRequest(1, ""))
+		}
+	}
+	for j := 0; j < 4; j++ {
+		mh.enqueue(2, &Request{conn: NewConnection(s, nil, 1, mh), msg: NewMsgPackage(highMsgID, nil)})
+	}
+	least := &LeastLoadedDispatcher{}
+	for i := 0; i < 4; i++ {
+		if id := least.Dispatch(newRequest(1, ""), taskQueues); id != 3 {
+			t.Errorf("LeastLoadedDispatcher workerID = %d, want 3", id)
+		}
+	}
+
+	//相同的key分配给同一个Worker,和连接无关
+	byScene := NewKeyDispatcher(func(request ziface.IRequest) uint64 {
+		return uint64(request.GetData()[0])
+	})
+	first := byScene.Dispatch(newRequest(1, "a"), taskQueues)
+	for connID := uint32(2); connID < 10; connID++ {
+		if id := byScene.Dispatch(newRequest(connID, "a"), taskQueues); id != first {
+			t.Errorf("KeyDispatcher conn %d workerID = %d, want %d", connID, id, first)
+		}
+	}
+}
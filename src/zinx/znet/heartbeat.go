@@ -0,0 +1,46 @@
+package znet
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//心跳检测,每隔HeartbeatInterval检查一次所有的连接,直到ctx被取消
+func (s *Server) startHeartbeat(ctx context.Context) {
+	interval := time.Duration(utils.GlobalObject.HeartbeatInterval) * time.Second
+	fmt.Println("[Zinx] Heartbeat is started, interval = ", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkHeartbeat(time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//检查所有连接的活跃时间,处理空闲超时的连接,并给其他连接发送心跳消息
+func (s *Server) checkHeartbeat(now time.Time) {
+	maxIdleTime := time.Duration(utils.GlobalObject.MaxIdleTime) * time.Second
+
+	s.ConnMgr.Range(func(conn ziface.IConnection) {
+		if maxIdleTime > 0 && now.Sub(conn.GetLastActivity()) > maxIdleTime {
+			s.CallOnConnIdle(conn)
+			return
+		}
+
+		//不论MsgChanOverflow是什么策略都不等待发送队列,发送队列满的慢连接跳过这一次心跳,避免阻塞整个心跳检测
+		if utils.GlobalObject.HeartbeatMsgID != 0 {
+			if err := trySendBuffMsg(conn, utils.GlobalObject.HeartbeatMsgID, nil); err != nil {
+				fmt.Println("send heartbeat to ConnID = ", conn.GetConnID(), " err: ", err)
+			}
+		}
+	})
+}
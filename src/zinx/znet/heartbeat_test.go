@@ -0,0 +1,101 @@
+package znet
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+func TestCheckHeartbeat(t *testing.T) {
+	oldMsgID, oldIdle := utils.GlobalObject.HeartbeatMsgID, utils.GlobalObject.MaxIdleTime
+	defer func() {
+		utils.GlobalObject.HeartbeatMsgID = oldMsgID
+		utils.GlobalObject.MaxIdleTime = oldIdle
+	}()
+	utils.GlobalObject.HeartbeatMsgID = 99
+	utils.GlobalObject.MaxIdleTime = 10
+
+	s := NewServer("[zinx test]").(*Server)
+
+	idleConn, idleClient := newTCPConnPair(t)
+	defer idleClient.Close()
+	idle := NewConnection(s, idleConn, 1, s.MsgHandler)
+	idle.Start()
+	//模拟一个很久没有收到消息的连接
+	atomic.StoreInt64(&idle.lastActivity, time.Now().Add(-time.Minute).UnixNano())
+
+	aliveConn, aliveClient := newTCPConnPair(t)
+	defer aliveClient.Close()
+	alive := NewConnection(s, aliveConn, 2, s.MsgHandler)
+	alive.Start()
+
+	//没有注册OnConnIdle,空闲超时的连接直接被关闭
+	s.checkHeartbeat(time.Now())
+	if !idle.IsClosed() {
+		t.Error("idle connection not stopped")
+	}
+	if alive.IsClosed() {
+		t.Error("alive connection stopped")
+	}
+
+	//活跃的连接收到心跳消息
+	dp := NewDataPack()
+	aliveClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	head := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(aliveClient, head); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := dp.Unpack(head)
+	if msg.GetMsgId() != 99 {
+		t.Errorf("heartbeat msgID = %d, want 99", msg.GetMsgId())
+	}
+
+	//注册了OnConnIdle,由开发者决定如何处理空闲的连接
+	var idleCalled int32
+	s.SetOnConnIdle(func(conn ziface.IConnection) {
+		atomic.AddInt32(&idleCalled, 1)
+	})
+	atomic.StoreInt64(&alive.lastActivity, time.Now().Add(-time.Minute).UnixNano())
+	s.checkHeartbeat(time.Now())
+	if atomic.LoadInt32(&idleCalled) != 1 {
+		t.Errorf("OnConnIdle called %d times, want 1", idleCalled)
+	}
+	if alive.IsClosed() {
+		t.Error("connection stopped when OnConnIdle is registered")
+	}
+	alive.Stop()
+}
+
+//MsgChanOverflowBlock并且不超时的配置下,发送队列满的连接也不能阻塞心跳检测
+func TestCheckHeartbeatFullQueue(t *testing.T) {
+	defer func(msgID uint32, policy string, timeout int, chanLen uint32) {
+		utils.GlobalObject.HeartbeatMsgID = msgID
+		utils.GlobalObject.MsgChanOverflow = policy
+		utils.GlobalObject.MsgChanBlockTimeout = timeout
+		utils.GlobalObject.MaxMsgChanLen = chanLen
+	}(utils.GlobalObject.HeartbeatMsgID, utils.GlobalObject.MsgChanOverflow,
+		utils.GlobalObject.MsgChanBlockTimeout, utils.GlobalObject.MaxMsgChanLen)
+	utils.GlobalObject.HeartbeatMsgID = 99
+	utils.GlobalObject.MsgChanOverflow = utils.MsgChanOverflowBlock
+	utils.GlobalObject.MsgChanBlockTimeout = 0
+	utils.GlobalObject.MaxMsgChanLen = 1
+
+	s := NewServer("[zinx test]").(*Server)
+	//没有启动Writer的连接,发送队列已经满了
+	conn := NewConnection(s, nil, 1, s.MsgHandler)
+	conn.msgBuffChan <- []byte("pending")
+
+	done := make(chan struct{})
+	go func() {
+		s.checkHeartbeat(time.Now())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("checkHeartbeat blocked by a full msg buff chan")
+	}
+}
@@ -4,6 +4,7 @@ type Message struct {
 	Id      uint32 //消息的ID
 	DataLen uint32 //消息的长度
 	Data    []byte //消息的内容
+	SeqID   uint32 //消息的seqID,只有携带seqID的封包格式才会发送
 }
 
 //创建一个Message消息包
@@ -44,3 +45,13 @@ func (m *Message) SetData(data []byte) {
 func (m *Message) SetDataLen(len uint32) {
 	m.DataLen = len
 }
+
+//获取消息的seqID
+func (m *Message) GetSeqID() uint32 {
+	return m.SeqID
+}
+
+//设置消息的seqID
+func (m *Message) SetSeqID(seqID uint32) {
+	m.SeqID = seqID
+}
@@ -1,55 +1,230 @@
 package znet
 
 import (
+	"errors"
 	"fmt"
-	"strconv"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"zinx/utils"
 	"zinx/ziface"
 )
 
+var (
+	//没有为消息的MsgID注册处理方法
+	ErrRouterNotFound = errors.New("router not found")
+	//MsgID已经注册过处理方法
+	ErrRouterExists = errors.New("repeat api")
+	//MsgID不在路由分组的范围之内
+	ErrMsgIDOutOfRange = errors.New("msgID out of router group range")
+)
+
+//处理消息时发生的panic,作为连接关闭的错误
+type PanicError struct {
+	Recovered interface{} //recover()的返回值
+	Stack     []byte      //panic时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Recovered)
+}
+
 //消息处理模块的实现
 type MsgHandle struct {
 	//存放每个MsgID所对应的处理方法
 	Apis map[uint32]ziface.IRouter
-	//负责Worker读取任务的消息队列
+	//对所有消息生效的中间件
+	middlewares []ziface.Middleware
+	//只对某个MsgID生效的中间件
+	msgMiddlewares map[uint32][]ziface.Middleware
+	//通过路由分组注册的MsgID所属的分组
+	routerGroups map[uint32]*RouterGroup
+	//每个MsgID经过中间件包装之后的处理函数,注册路由和中间件时重新生成
+	chains map[uint32]ziface.HandlerFunc
+	//没有注册路由的消息经过全局中间件包装之后的处理函数
+	notFoundChain ziface.HandlerFunc
+	//没有注册路由的消息交给的兜底路由,为nil时只按照NotFoundPolicy处理
+	notFoundRouter ziface.IRouter
+	//保护连接上未注册消息计数的锁
+	strikeLock sync.Mutex
+	//处理消息发生panic时调用的Hook函数
+	OnPanic func(request ziface.IRequest, recovered interface{}, stack []byte)
+	//负责Worker读取任务的消息队列,设置过优先级时只存放PriorityNormal的消息
 	TaskQueue []chan ziface.IRequest
+	//设置过优先级的消息的优先级
+	priorities map[uint32]ziface.Priority
+	//设置过优先级时,每个Worker存放高优先级和低优先级消息的队列
+	highQueue []chan ziface.IRequest
+	lowQueue  []chan ziface.IRequest
+	//每个Worker的低优先级队列连续被跳过的次数,只由处理该Worker队列的Goroutine读写
+	laneSkips [][priorityNum]int
+	//设置过优先级时,通知每个Worker队列中放入了请求,容量为1
+	laneReady []chan struct{}
 	//业务工作Worker池的worker数量
 	WorkerPoolSize uint32
+	//把请求分配给Worker的策略
+	dispatcher ziface.IDispatcher
+	//每个Worker的计数器
+	workerCounters []workerCounter
+	//等待所有Worker退出的WaitGroup
+	workerWg sync.WaitGroup
+	//开启WorkerPoolMaxSize时的弹性Worker池,为nil时每个TaskQueue固定一个Worker
+	pool *elasticPool
 }
 
 //初始化/创建MsgHandle的方法
 func NewMsgHandle() *MsgHandle {
-	return &MsgHandle{
+	mh := &MsgHandle{
 		Apis:           make(map[uint32]ziface.IRouter),
+		msgMiddlewares: make(map[uint32][]ziface.Middleware),
+		routerGroups:   make(map[uint32]*RouterGroup),
+		chains:         make(map[uint32]ziface.HandlerFunc),
+		priorities:     make(map[uint32]ziface.Priority),
 		WorkerPoolSize: utils.GlobalObject.WorkerPoolSize, //从全局配置中获取
 		TaskQueue:      make([]chan ziface.IRequest, utils.GlobalObject.WorkerPoolSize),
+		dispatcher:     &ConnDispatcher{},
+		workerCounters: make([]workerCounter, utils.GlobalObject.WorkerPoolSize),
 	}
+	mh.notFoundChain = mh.handleNotFound
+	return mh
 }
 
 //调度/执行对应的Router消息处理方法
 func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
-	//1 从Request中找到msgID
-	handler, ok := mh.Apis[request.GetMsgID()]
+	//一个请求的panic不能影响Worker继续处理后面的请求
+	defer mh.recoverPanic(request)
+
+	//1 从Request中找到msgID对应的处理函数,没有注册时仍然经过全局中间件
+	chain, ok := mh.chains[request.GetMsgID()]
 	if !ok {
-		fmt.Println("api msgID = ", request.GetMsgID(), " is NOT FOUND! Need Register!")
+		chain = mh.notFoundChain
+	}
+	//2 依次经过中间件,最后调度对应router业务
+	if err := chain(request); err != nil {
+		fmt.Println("handle msgID = ", request.GetMsgID(), " err: ", err)
+	}
+}
+
+//恢复处理request时发生的panic
+func (mh *MsgHandle) recoverPanic(request ziface.IRequest) {
+	recovered := recover()
+	if recovered == nil {
 		return
 	}
-	//2 根据MsgID 调度对应router业务即可
-	handler.PreHandle(request)
-	handler.Handle(request)
-	handler.PostHandle(request)
+	stack := debug.Stack()
+
+	conn := request.GetConnection()
+	var connID uint32
+	if conn != nil {
+		connID = conn.GetConnID()
+	}
+	fmt.Printf("[Zinx] panic when handle ConnID = %d msgID = %d: %v\n%s", connID, request.GetMsgID(), recovered, stack)
+
+	if mh.OnPanic != nil {
+		mh.OnPanic(request, recovered, stack)
+	}
+
+	//按照配置关闭发送该消息的连接
+	if utils.GlobalObject.PanicCloseConn && conn != nil {
+		conn.StopWithReason(ziface.CloseReasonPanic, &PanicError{Recovered: recovered, Stack: stack})
+	}
+}
+
+//注册处理消息发生panic时调用的Hook函数
+func (mh *MsgHandle) SetOnPanic(hookFunc func(request ziface.IRequest, recovered interface{}, stack []byte)) {
+	mh.OnPanic = hookFunc
+}
+
+//依次调用router的PreHandle,Handle,PostHandle
+func routerHandler(router ziface.IRouter) ziface.HandlerFunc {
+	return func(request ziface.IRequest) error {
+		router.PreHandle(request)
+		router.Handle(request)
+		router.PostHandle(request)
+		return nil
+	}
 }
 
-//为消息添加具体的处理逻辑
+//用全局中间件,路由分组的中间件和msgID的中间件包装handler,先添加的中间件在最外层
+//执行顺序为: 全局中间件 -> 外层分组的中间件 -> 内层分组的中间件 -> msgID的中间件 -> router
+func (mh *MsgHandle) buildChain(msgID uint32, handler ziface.HandlerFunc) ziface.HandlerFunc {
+	msgMiddlewares := mh.msgMiddlewares[msgID]
+	for i := len(msgMiddlewares) - 1; i >= 0; i-- {
+		handler = msgMiddlewares[i](handler)
+	}
+	for g := mh.routerGroups[msgID]; g != nil; g = g.parent {
+		for i := len(g.middlewares) - 1; i >= 0; i-- {
+			handler = g.middlewares[i](handler)
+		}
+	}
+	for i := len(mh.middlewares) - 1; i >= 0; i-- {
+		handler = mh.middlewares[i](handler)
+	}
+	return handler
+}
+
+//注册路由或者中间件之后,重新生成所有的处理函数
+func (mh *MsgHandle) rebuildChains() {
+	for msgID, router := range mh.Apis {
+		mh.chains[msgID] = mh.buildChain(msgID, routerHandler(router))
+	}
+	//没有注册路由的消息只经过全局中间件
+	notFound := ziface.HandlerFunc(mh.handleNotFound)
+	for i := len(mh.middlewares) - 1; i >= 0; i-- {
+		notFound = mh.middlewares[i](notFound)
+	}
+	mh.notFoundChain = notFound
+}
+
+//添加对所有消息生效的中间件,需要在Server启动之前调用
+func (mh *MsgHandle) Use(middlewares ...ziface.Middleware) {
+	mh.middlewares = append(mh.middlewares, middlewares...)
+	mh.rebuildChains()
+}
+
+//添加只对msgID生效的中间件,需要在Server启动之前调用
+func (mh *MsgHandle) UseFor(msgID uint32, middlewares ...ziface.Middleware) {
+	mh.msgMiddlewares[msgID] = append(mh.msgMiddlewares[msgID], middlewares...)
+	mh.rebuildChains()
+}
+
+//为消息添加具体的处理逻辑,msgID已经注册过时panic
 func (mh *MsgHandle) AddRouter(msgID uint32, router ziface.IRouter) {
+	if err := mh.TryAddRouter(msgID, router); err != nil {
+		panic(err.Error())
+	}
+}
+
+//为消息添加具体的处理逻辑,msgID已经注册过时返回ErrRouterExists
+func (mh *MsgHandle) TryAddRouter(msgID uint32, router ziface.IRouter) error {
+	return mh.addRouter(msgID, router, nil)
+}
+
+//为消息添加一个处理函数
+func (mh *MsgHandle) AddHandlerFunc(msgID uint32, handler func(request ziface.IRequest)) {
+	mh.AddRouter(msgID, &funcRouter{handle: handler})
+}
+
+//创建一个路由分组,分组内的路由的msgID必须在[start, end]之内
+func (mh *MsgHandle) Group(start, end uint32, middlewares ...ziface.Middleware) ziface.IRouterGroup {
+	return newRouterGroup(mh, nil, start, end, middlewares)
+}
+
+//添加msgID与router的绑定关系,group为router所属的路由分组
+func (mh *MsgHandle) addRouter(msgID uint32, router ziface.IRouter, group *RouterGroup) error {
 	//1 判断当前msg绑定的API处理方法是否已经存在
 	if _, ok := mh.Apis[msgID]; ok {
 		//id已经注册了
-		panic("repeat api, msgID = " + strconv.Itoa(int(msgID)))
+		return fmt.Errorf("%w, msgID = %d", ErrRouterExists, msgID)
 	}
 	//2.添加msg与API的绑定关系
 	mh.Apis[msgID] = router
+	if group != nil {
+		mh.routerGroups[msgID] = group
+	}
+	mh.chains[msgID] = mh.buildChain(msgID, routerHandler(router))
 	fmt.Println("Add api NMsgID = ", msgID, " succ!")
+	return nil
 }
 
 //启动一个Worker工作池(开启工作池的动作只能发生一次,一个zinx框架只能有一个worker工作池)
@@ -59,34 +234,83 @@ func (mh *MsgHandle) StartWorkerPool() {
 		//一个worker被启动
 		//1 当前的worker对应的channel消息队列 开辟空间 第0个worker就用第0个channel...
 		mh.TaskQueue[i] = make(chan ziface.IRequest, utils.GlobalObject.MaxWorkerTaskLen)
-		//2 启动当前的Worker,阻塞等待消息从channel传递过来
-		go mh.startOneWorker(i, mh.TaskQueue[i])
+	}
+	//设置过优先级时开辟高优先级和低优先级的队列
+	mh.startPriorityQueues()
+
+	//弹性Worker池中Worker不绑定TaskQueue
+	if !elasticEnabled() {
+		for i := uint32(0); i < mh.WorkerPoolSize; i++ {
+			//2 启动当前的Worker,阻塞等待消息从channel传递过来
+			mh.workerWg.Add(1)
+			go mh.startOneWorker(i)
+		}
+	}
+	if elasticEnabled() && mh.WorkerPoolSize > 0 {
+		mh.startElasticPool()
 	}
 }
 
+//停止Worker工作池(调用前需保证已经没有Reader再向TaskQueue投递消息)
+func (mh *MsgHandle) StopWorkerPool() {
+	//关闭所有的消息队列,Worker会把队列中剩余的Request处理完之后再退出
+	for _, taskQueue := range mh.TaskQueue {
+		if taskQueue != nil {
+			close(taskQueue)
+		}
+	}
+	mh.closePriorityQueues()
+
+	//阻塞等待所有的Worker退出
+	if mh.pool != nil {
+		mh.stopElasticPool()
+	} else {
+		mh.workerWg.Wait()
+	}
+	fmt.Println("All workers are stopped")
+}
+
 //启动一个Worker工作流程
-func (mh *MsgHandle) startOneWorker(workerID int, taskQueue chan ziface.IRequest) {
+func (mh *MsgHandle) startOneWorker(workerID uint32) {
 	fmt.Println("Worker ID = ", workerID, " is started ...")
+	defer mh.workerWg.Done()
+	defer fmt.Println("Worker ID = ", workerID, " is stopped ...")
 
-	//不断的阻塞等待对应消息队列的消息
+	//不断的阻塞等待对应消息队列的消息,直到消息队列被关闭并且处理完剩余的消息
 	for {
-		select {
-		//如果有消息过来,出列的就是一个客户端的Request,执行当前request所绑定业务
-		case request := <-taskQueue:
-			mh.DoMsgHandler(request)
+		//先按照优先级取出队列中的请求,所有队列都为空时再阻塞等待
+		request, ok := mh.nextRequest(workerID)
+		if !ok {
+			if request, ok = mh.waitRequest(workerID); !ok {
+				return
+			}
 		}
+		//如果有消息过来,出列的就是一个客户端的Request,执行当前request所绑定业务
+		mh.DoMsgHandler(request)
+		atomic.AddUint64(&mh.workerCounters[workerID].processed, 1)
 	}
 }
 
 //将消息交给TaskQueue,由Worker进行处理
 func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
-	//1 将消息平均分配给不同的worker
-	//根据客户端建立的ConnID来进行分配
-	workerID := request.GetConnection().GetConnID() % mh.WorkerPoolSize
+	//1 按照分配策略将消息分配给不同的worker,默认根据客户端建立的ConnID来进行分配
+	//取模保证自定义的策略返回的WorkerID不会越界
+	workerID := mh.dispatcher.Dispatch(request, workerQueues{mh}) % mh.WorkerPoolSize
 	fmt.Println("Add ConnID = ", request.GetConnection().GetConnID(),
 		" request MsgID", request.GetMsgID(),
 		" to WorkerID = ", workerID)
 
-	//2 将消息发送给对应的worker的TaskQueue即可
-	mh.TaskQueue[workerID] <- request
+	//2 将消息发送给对应的worker的TaskQueue即可,队列满时按照配置的TaskQueueOverflow策略处理
+	mh.enqueue(workerID, request)
+	//3 弹性Worker池中需要把有请求的TaskQueue交给空闲的Worker,设置过优先级时需要唤醒等待的Worker
+	if mh.pool != nil {
+		mh.schedule(workerID)
+	} else if mh.laneReady != nil {
+		mh.notifyLane(workerID)
+	}
+}
+
+//设置把请求分配给Worker的策略,需要在Server启动之前调用
+func (mh *MsgHandle) SetDispatcher(dispatcher ziface.IDispatcher) {
+	mh.dispatcher = dispatcher
 }
@@ -0,0 +1,267 @@
+package znet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//记录调用顺序的路由
+type traceRouter struct {
+	BaseRouter
+	trace *[]string
+}
+
+func (r *traceRouter) Handle(request ziface.IRequest) {
+	*r.trace = append(*r.trace, "router")
+}
+
+//记录调用顺序的中间件
+func traceMiddleware(trace *[]string, name string) ziface.Middleware {
+	return func(next ziface.HandlerFunc) ziface.HandlerFunc {
+		return func(request ziface.IRequest) error {
+			*trace = append(*trace, name+">")
+			err := next(request)
+			*trace = append(*trace, "<"+name)
+			return err
+		}
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var trace []string
+	var errs []error
+	errDenied := errors.New("denied")
+
+	mh := NewMsgHandle()
+	//中间件可以在路由之前或者之后添加
+	mh.AddRouter(1, &traceRouter{trace: &trace})
+	mh.Use(func(next ziface.HandlerFunc) ziface.HandlerFunc {
+		return func(request ziface.IRequest) error {
+			err := next(request)
+			errs = append(errs, err)
+			return err
+		}
+	})
+	mh.Use(traceMiddleware(&trace, "a"), traceMiddleware(&trace, "b"))
+	mh.UseFor(1, traceMiddleware(&trace, "c"))
+	mh.AddRouter(2, &traceRouter{trace: &trace})
+	//不调用next,中断后续的处理
+	mh.UseFor(2, func(next ziface.HandlerFunc) ziface.HandlerFunc {
+		return func(request ziface.IRequest) error {
+			return errDenied
+		}
+	})
+
+	mh.DoMsgHandler(&Request{msg: NewMsgPackage(1, nil)})
+	want := []string{"a>", "b>", "c>", "router", "<c", "<b", "<a"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("msg 1 trace = %v, want %v", trace, want)
+	}
+
+	trace = nil
+	mh.DoMsgHandler(&Request{msg: NewMsgPackage(2, nil)})
+	want = []string{"a>", "b>", "<b", "<a"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("msg 2 trace = %v, want %v", trace, want)
+	}
+
+	//没有注册路由的消息只经过全局中间件
+	trace = nil
+	mh.DoMsgHandler(&Request{msg: NewMsgPackage(3, nil)})
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("msg 3 trace = %v, want %v", trace, want)
+	}
+
+	wantErrs := []error{nil, errDenied, ErrRouterNotFound}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("observed errs = %v, want %v", errs, wantErrs)
+	}
+}
+
+//处理消息时panic的路由
+type panicRouter struct {
+	BaseRouter
+}
+
+func (r *panicRouter) Handle(request ziface.IRequest) {
+	panic("handle msg " + string(request.GetData()))
+}
+
+func TestPanicRecovery(t *testing.T) {
+	defer func(old bool) { utils.GlobalObject.PanicCloseConn = old }(utils.GlobalObject.PanicCloseConn)
+
+	s := newTestServer(t, 7793)
+	s.AddRouter(1, &panicRouter{})
+	s.AddRouter(2, &echoRouter{})
+	panics := make(chan interface{}, 10)
+	s.SetOnPanic(func(request ziface.IRequest, recovered interface{}, stack []byte) {
+		panics <- recovered
+	})
+	closeErrs := make(chan *ziface.CloseError, 10)
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		closeErrs <- closeErr
+	})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialTestServer(t, 7793)
+	defer conn.Close()
+	dp := NewDataPack()
+	reader := bufio.NewReader(conn)
+
+	//同一个连接的消息由同一个Worker处理,panic之后Worker继续处理后面的消息
+	for _, msg := range []*Message{NewMsgPackage(1, []byte("boom")), NewMsgPackage(2, []byte("still alive"))} {
+		data, _ := dp.Pack(msg)
+		if _, err := conn.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := readMessage(reader, dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "still alive" {
+		t.Errorf("recv %q after panic", msg.GetData())
+	}
+	select {
+	case recovered := <-panics:
+		if recovered != "handle msg boom" {
+			t.Errorf("OnPanic recovered = %v", recovered)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPanic not called")
+	}
+
+	//开启PanicCloseConn之后,panic的连接被关闭
+	utils.GlobalObject.PanicCloseConn = true
+	data, _ := dp.Pack(NewMsgPackage(1, []byte("again")))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case closeErr := <-closeErrs:
+		var panicErr *PanicError
+		if closeErr.Reason != ziface.CloseReasonPanic || !errors.As(closeErr, &panicErr) {
+			t.Errorf("close err = %v", closeErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed after panic")
+	}
+}
+
+func TestRouterGroup(t *testing.T) {
+	var trace []string
+	mh := NewMsgHandle()
+	mh.Use(traceMiddleware(&trace, "a"))
+
+	player := mh.Group(100, 199, traceMiddleware(&trace, "player"))
+	bag := player.Group(150, 159)
+	bag.Use(traceMiddleware(&trace, "bag"))
+	bag.AddHandlerFunc(151, func(request ziface.IRequest) {
+		trace = append(trace, "handler")
+	})
+	mh.UseFor(151, traceMiddleware(&trace, "m"))
+	player.AddHandlerFunc(100, func(request ziface.IRequest) {
+		trace = append(trace, "handler")
+	})
+
+	mh.DoMsgHandler(&Request{msg: NewMsgPackage(151, nil)})
+	want := []string{"a>", "player>", "bag>", "m>", "handler", "<m", "<bag", "<player", "<a"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("msg 151 trace = %v, want %v", trace, want)
+	}
+
+	trace = nil
+	mh.DoMsgHandler(&Request{msg: NewMsgPackage(100, nil)})
+	want = []string{"a>", "player>", "handler", "<player", "<a"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("msg 100 trace = %v, want %v", trace, want)
+	}
+
+	if err := player.TryAddRouter(151, &BaseRouter{}); !errors.Is(err, ErrRouterExists) {
+		t.Errorf("TryAddRouter repeat msgID err = %v, want %v", err, ErrRouterExists)
+	}
+	if err := bag.TryAddRouter(160, &BaseRouter{}); !errors.Is(err, ErrMsgIDOutOfRange) {
+		t.Errorf("TryAddRouter out of range err = %v, want %v", err, ErrMsgIDOutOfRange)
+	}
+	if err := mh.TryAddRouter(160, &BaseRouter{}); err != nil {
+		t.Errorf("TryAddRouter err = %v", err)
+	}
+
+	//AddRouter在msgID重复时仍然panic
+	defer func() {
+		if r := recover(); r != "repeat api, msgID = 160" {
+			t.Errorf("AddRouter repeat msgID recovered = %v", r)
+		}
+	}()
+	mh.AddRouter(160, &BaseRouter{})
+}
+
+func TestNotFound(t *testing.T) {
+	defer func(policy string, msgID uint32, strikes int) {
+		utils.GlobalObject.NotFoundPolicy = policy
+		utils.GlobalObject.NotFoundMsgID = msgID
+		utils.GlobalObject.NotFoundMaxStrikes = strikes
+	}(utils.GlobalObject.NotFoundPolicy, utils.GlobalObject.NotFoundMsgID, utils.GlobalObject.NotFoundMaxStrikes)
+	utils.GlobalObject.NotFoundPolicy = utils.NotFoundReply
+	utils.GlobalObject.NotFoundMsgID = 404
+	utils.GlobalObject.NotFoundMaxStrikes = 2
+
+	s := newTestServer(t, 7794)
+	fallback := &recordRouter{msgs: make(chan string, 10)}
+	s.SetNotFoundRouter(fallback)
+	closeErrs := make(chan *ziface.CloseError, 1)
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		closeErrs <- closeErr
+	})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialTestServer(t, 7794)
+	defer conn.Close()
+	dp := NewDataPack()
+	send := func(msgID uint32, data string) {
+		buf, _ := dp.Pack(NewMsgPackage(msgID, []byte(data)))
+		if _, err := conn.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//兜底路由收到消息之后,回复客户端NotFoundMsg
+	send(9, "unknown")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := readMessage(bufio.NewReader(conn), dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply NotFoundMsg
+	if err := json.Unmarshal(msg.GetData(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgId() != 404 || reply.Code != NotFoundCode || reply.MsgID != 9 {
+		t.Errorf("not found reply msgID = %d, data = %s", msg.GetMsgId(), msg.GetData())
+	}
+	if got := <-fallback.msgs; got != "unknown" {
+		t.Errorf("fallback router recv %q", got)
+	}
+
+	//累计达到NotFoundMaxStrikes之后断开连接
+	utils.GlobalObject.NotFoundPolicy = utils.NotFoundDisconnect
+	send(9, "1")
+	send(10, "2")
+	select {
+	case closeErr := <-closeErrs:
+		if closeErr.Reason != ziface.CloseReasonProtocolError || !errors.Is(closeErr, ErrRouterNotFound) {
+			t.Errorf("close err = %v", closeErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed after too many unknown msgs")
+	}
+}
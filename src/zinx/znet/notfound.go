@@ -0,0 +1,81 @@
+package znet
+
+import (
+	"encoding/json"
+	"fmt"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//NotFoundReply策略下回复给客户端的错误码
+const NotFoundCode = 404
+
+//连接属性中记录未注册消息个数的key
+const notFoundStrikesKey = "zinx.notFoundStrikes"
+
+//收到没有注册路由的消息时,回复给客户端的消息内容(JSON编码)
+type NotFoundMsg struct {
+	Code  int    `json:"code"`   //错误码,固定为NotFoundCode
+	MsgID uint32 `json:"msg_id"` //客户端发送的没有注册路由的消息ID
+	Msg   string `json:"msg"`    //提示信息
+}
+
+//设置兜底路由
+func (mh *MsgHandle) SetNotFoundRouter(router ziface.IRouter) {
+	mh.notFoundRouter = router
+}
+
+//处理没有注册路由的消息,先交给兜底路由,再按照NotFoundPolicy处理
+func (mh *MsgHandle) handleNotFound(request ziface.IRequest) error {
+	fmt.Println("api msgID = ", request.GetMsgID(), " is NOT FOUND! Need Register!")
+
+	if mh.notFoundRouter != nil {
+		mh.notFoundRouter.PreHandle(request)
+		mh.notFoundRouter.Handle(request)
+		mh.notFoundRouter.PostHandle(request)
+	}
+
+	conn := request.GetConnection()
+	if conn == nil {
+		return ErrRouterNotFound
+	}
+
+	switch utils.GlobalObject.NotFoundPolicy {
+	case utils.NotFoundReply:
+		if utils.GlobalObject.NotFoundMsgID == 0 {
+			break
+		}
+		data, err := json.Marshal(&NotFoundMsg{
+			Code:  NotFoundCode,
+			MsgID: request.GetMsgID(),
+			Msg:   ErrRouterNotFound.Error(),
+		})
+		if err != nil {
+			fmt.Println("marshal not found msg err: ", err)
+			break
+		}
+		if err := conn.SendMsg(utils.GlobalObject.NotFoundMsgID, data); err != nil {
+			fmt.Println("send not found msg err: ", err)
+		}
+	case utils.NotFoundDisconnect:
+		if mh.addStrike(conn) >= utils.GlobalObject.NotFoundMaxStrikes {
+			fmt.Println("ConnID = ", conn.GetConnID(), " sent too many unknown msgs, stop it")
+			conn.StopWithReason(ziface.CloseReasonProtocolError, ErrRouterNotFound)
+		}
+	}
+
+	return ErrRouterNotFound
+}
+
+//连接上未注册消息的个数加一,返回加一之后的个数
+func (mh *MsgHandle) addStrike(conn ziface.IConnection) int {
+	mh.strikeLock.Lock()
+	defer mh.strikeLock.Unlock()
+
+	strikes := 1
+	if value, err := conn.GetProperty(notFoundStrikesKey); err == nil {
+		strikes = value.(int) + 1
+	}
+	conn.SetProperty(notFoundStrikesKey, strikes)
+	return strikes
+}
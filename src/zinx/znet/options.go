@@ -0,0 +1,67 @@
+package znet
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+	"zinx/ziface"
+)
+
+//创建Server时的可选配置项
+type Option func(s *Server)
+
+//设置Server所有连接使用的封包拆包模块,替换默认的 MsgDataLen|MsgID|Data 格式
+func WithPacket(packet ziface.IDataPack) Option {
+	return func(s *Server) {
+		s.packet = packet
+	}
+}
+
+//设置Server使用的TLS配置,设置之后所有的连接都使用TLS,优先于zinx.json中配置的证书
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+//设置Server使用的监听器,Server不再根据IPVersion,IP和Port自己监听
+//可以传入Unix socket,tcp6等任意的net.Listener,Server关闭时会关闭该监听器
+func WithListener(listener net.Listener) Option {
+	return func(s *Server) {
+		s.listener = listener
+	}
+}
+
+//创建Client时的可选配置项
+type ClientOption func(c *Client)
+
+//设置Client使用的封包拆包模块,需要和服务器使用的格式一致
+func WithClientPacket(packet ziface.IDataPack) ClientOption {
+	return func(c *Client) {
+		c.packet = packet
+	}
+}
+
+//设置Client连接服务器的方法,可以用来建立TLS,Unix socket或者UDP(DialUDP)的连接
+func WithDialer(dial func() (net.Conn, error)) ClientOption {
+	return func(c *Client) {
+		c.dial = dial
+	}
+}
+
+//设置Client断线重连的退避时间,从minDelay开始每次失败翻倍,最长maxDelay,minDelay<=0表示不重连
+//连接保持了maxDelay(maxDelay<=0时为30秒)以上才算稳定,之后断开时退避时间才会重新从minDelay开始
+func WithReconnect(minDelay, maxDelay time.Duration) ClientOption {
+	return func(c *Client) {
+		c.reconnectMin = minDelay
+		c.reconnectMax = maxDelay
+	}
+}
+
+//设置Client每隔interval给服务器发送一个msgID的心跳消息,interval<=0表示不发送心跳
+func WithClientHeartbeat(interval time.Duration, msgID uint32) ClientOption {
+	return func(c *Client) {
+		c.heartbeatInterval = interval
+		c.heartbeatMsgID = msgID
+	}
+}
@@ -0,0 +1,184 @@
+package znet
+
+import (
+	"fmt"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//优先级的个数,也是每个Worker的队列个数
+const priorityNum = int(ziface.PriorityLow) + 1
+
+//为消息添加具体的处理逻辑,并设置消息的优先级,msgID已经注册过时panic
+func (mh *MsgHandle) AddRouterWithPriority(msgID uint32, router ziface.IRouter, priority ziface.Priority) {
+	mh.AddRouter(msgID, router)
+	mh.SetPriority(msgID, priority)
+}
+
+//设置消息的优先级,需要在启动Worker工作池之前调用
+//设置过优先级之后,每个Worker会为高优先级和低优先级的消息各开辟一个队列,
+//同一个连接不同优先级的消息不再保证按照发送的顺序处理
+func (mh *MsgHandle) SetPriority(msgID uint32, priority ziface.Priority) {
+	if priority < ziface.PriorityHigh || priority > ziface.PriorityLow {
+		panic(fmt.Sprintf("invalid priority %d, msgID = %d", priority, msgID))
+	}
+	if priority == ziface.PriorityNormal {
+		delete(mh.priorities, msgID)
+		return
+	}
+	mh.priorities[msgID] = priority
+}
+
+//为设置过优先级的消息开辟高优先级和低优先级的队列,普通优先级的队列仍然是TaskQueue
+func (mh *MsgHandle) startPriorityQueues() {
+	if len(mh.priorities) == 0 {
+		return
+	}
+	mh.highQueue = make([]chan ziface.IRequest, mh.WorkerPoolSize)
+	mh.lowQueue = make([]chan ziface.IRequest, mh.WorkerPoolSize)
+	for i := range mh.highQueue {
+		mh.highQueue[i] = make(chan ziface.IRequest, utils.GlobalObject.MaxWorkerTaskLen)
+		mh.lowQueue[i] = make(chan ziface.IRequest, utils.GlobalObject.MaxWorkerTaskLen)
+	}
+	mh.laneSkips = make([][priorityNum]int, mh.WorkerPoolSize)
+	mh.laneReady = make([]chan struct{}, mh.WorkerPoolSize)
+	for i := range mh.laneReady {
+		mh.laneReady[i] = make(chan struct{}, 1)
+	}
+}
+
+//关闭高优先级和低优先级的队列
+func (mh *MsgHandle) closePriorityQueues() {
+	for i := range mh.highQueue {
+		close(mh.highQueue[i])
+		close(mh.lowQueue[i])
+	}
+	for i := range mh.laneReady {
+		close(mh.laneReady[i])
+	}
+}
+
+//通知Worker队列中放入了请求,Worker还没有被唤醒时不重复通知
+func (mh *MsgHandle) notifyLane(workerID uint32) {
+	select {
+	case mh.laneReady[workerID] <- struct{}{}:
+	default:
+	}
+}
+
+//request应该放入的Worker的队列
+func (mh *MsgHandle) laneQueue(workerID uint32, request ziface.IRequest) chan ziface.IRequest {
+	//没有设置过优先级的消息放入TaskQueue
+	if priority, ok := mh.priorities[request.GetMsgID()]; ok && mh.highQueue != nil {
+		switch priority {
+		case ziface.PriorityHigh:
+			return mh.highQueue[workerID]
+		case ziface.PriorityLow:
+			return mh.lowQueue[workerID]
+		}
+	}
+	return mh.TaskQueue[workerID]
+}
+
+//Worker按照优先级从高到低排列的队列,没有设置过优先级时只有TaskQueue
+func (mh *MsgHandle) lanes(workerID uint32) [priorityNum]chan ziface.IRequest {
+	var lanes [priorityNum]chan ziface.IRequest
+	lanes[ziface.PriorityNormal] = mh.TaskQueue[workerID]
+	if mh.highQueue != nil {
+		lanes[ziface.PriorityHigh] = mh.highQueue[workerID]
+		lanes[ziface.PriorityLow] = mh.lowQueue[workerID]
+	}
+	return lanes
+}
+
+//Worker所有队列中等待处理的请求数
+func (mh *MsgHandle) laneLen(workerID uint32) int {
+	n := 0
+	for _, lane := range mh.lanes(workerID) {
+		n += len(lane)
+	}
+	return n
+}
+
+//MsgHandle中所有Worker的队列,提供给IDispatcher
+type workerQueues struct {
+	mh *MsgHandle
+}
+
+func (q workerQueues) Len() int {
+	return len(q.mh.TaskQueue)
+}
+
+func (q workerQueues) QueueLen(workerID uint32) int {
+	return q.mh.laneLen(workerID)
+}
+
+//非阻塞地取出Worker下一个要处理的请求,所有队列都为空时返回false
+//优先取高优先级队列中的请求,低优先级队列已经连续被跳过PriorityStarvationLimit次时先处理它
+//同一个Worker的队列同一时刻只会被一个Goroutine读取
+func (mh *MsgHandle) nextRequest(workerID uint32) (ziface.IRequest, bool) {
+	lanes := mh.lanes(workerID)
+	chosen := -1
+
+	limit := utils.GlobalObject.PriorityStarvationLimit
+	if limit > 0 && mh.laneSkips != nil {
+		skips := &mh.laneSkips[workerID]
+		for p := priorityNum - 1; p > 0; p-- {
+			if skips[p] >= limit && len(lanes[p]) > 0 {
+				chosen = p
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		for p := range lanes {
+			if len(lanes[p]) > 0 {
+				chosen = p
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		return nil, false
+	}
+
+	select {
+	case request, ok := <-lanes[chosen]:
+		if !ok {
+			return nil, false
+		}
+		//记录比选中的队列优先级低,但是有请求在等待的队列被跳过的次数
+		if mh.laneSkips != nil {
+			skips := &mh.laneSkips[workerID]
+			skips[chosen] = 0
+			for p := chosen + 1; p < priorityNum; p++ {
+				if len(lanes[p]) > 0 {
+					skips[p]++
+				}
+			}
+		}
+		return request, true
+	default:
+		//请求被TaskQueueOverflowDropOldest策略丢弃了
+		return nil, false
+	}
+}
+
+//所有队列都为空时,阻塞等待任意一个队列的请求,所有队列都被关闭并且处理完之后返回false
+//设置过优先级时被唤醒后仍然通过nextRequest选择队列,保证优先级和低优先级队列被跳过的次数
+func (mh *MsgHandle) waitRequest(workerID uint32) (ziface.IRequest, bool) {
+	//没有设置过优先级时只有TaskQueue
+	if mh.laneReady == nil {
+		request, ok := <-mh.TaskQueue[workerID]
+		return request, ok
+	}
+	for {
+		if request, ok := mh.nextRequest(workerID); ok {
+			return request, true
+		}
+		if _, ok := <-mh.laneReady[workerID]; !ok {
+			//队列已经被关闭,取出剩余的请求
+			return mh.nextRequest(workerID)
+		}
+	}
+}
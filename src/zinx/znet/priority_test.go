@@ -0,0 +1,128 @@
+package znet
+
+import (
+	"sync"
+	"testing"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//Worker处理请求的顺序:高优先级优先,低优先级的请求不会被一直跳过
+func TestPriorityLanes(t *testing.T) {
+	defer func(size uint32, limit int) {
+		utils.GlobalObject.WorkerPoolSize = size
+		utils.GlobalObject.PriorityStarvationLimit = limit
+	}(utils.GlobalObject.WorkerPoolSize, utils.GlobalObject.PriorityStarvationLimit)
+	utils.GlobalObject.WorkerPoolSize = 1
+
+	const (
+		highMsgID   = 1
+		normalMsgID = 2
+		lowMsgID    = 3
+		blockMsgID  = 4
+	)
+
+	run := func(limit int) []uint32 {
+		utils.GlobalObject.PriorityStarvationLimit = limit
+
+		s := NewServer("[zinx test]").(*Server)
+		var lock sync.Mutex
+		var order []uint32
+		record := func(request ziface.IRequest) {
+			lock.Lock()
+			order = append(order, request.GetMsgID())
+			lock.Unlock()
+		}
+		s.AddRouterWithPriority(highMsgID, &funcRouter{handle: record}, ziface.PriorityHigh)
+		s.AddHandlerFunc(normalMsgID, record)
+		s.AddRouterWithPriority(lowMsgID, &funcRouter{handle: record}, ziface.PriorityLow)
+		//阻塞Worker,让所有的请求都在队列中等待
+		started, release := make(chan struct{}), make(chan struct{})
+		s.AddHandlerFunc(blockMsgID, func(request ziface.IRequest) {
+			close(started)
+			<-release
+		})
+
+		mh := s.MsgHandler.(*MsgHandle)
+		mh.StartWorkerPool()
+		conn := NewConnection(s, nil, 0, mh)
+		send := func(msgID uint32) {
+			mh.SendMsgToTaskQueue(&Request{conn: conn, msg: NewMsgPackage(msgID, nil)})
+		}
+
+		send(blockMsgID)
+		<-started
+		for i := 0; i < 4; i++ {
+			send(lowMsgID)
+			send(normalMsgID)
+			send(highMsgID)
+		}
+		if n := mh.GetWorkerStats()[0].QueueLen; n != 12 {
+			t.Errorf("QueueLen = %d, want 12", n)
+		}
+		close(release)
+		mh.StopWorkerPool()
+		return order
+	}
+
+	//严格按照优先级处理
+	order := run(0)
+	want := []uint32{1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3}
+	if len(order) != len(want) {
+		t.Fatalf("strict order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("strict order = %v, want %v", order, want)
+		}
+	}
+
+	//低优先级的请求最多被连续跳过2次
+	order = run(2)
+	if len(order) != 12 {
+		t.Fatalf("handled %d requests, want 12", len(order))
+	}
+	if order[0] != highMsgID || order[1] != highMsgID {
+		t.Errorf("starvation order = %v, want high priority first", order)
+	}
+	if order[2] != lowMsgID {
+		t.Errorf("starvation order = %v, want low priority after 2 high priority", order)
+	}
+}
+
+//Worker阻塞等待时同时放入不同优先级的请求,被唤醒之后仍然按照优先级处理
+func TestPriorityWakeup(t *testing.T) {
+	defer func(size uint32, limit int) {
+		utils.GlobalObject.WorkerPoolSize = size
+		utils.GlobalObject.PriorityStarvationLimit = limit
+	}(utils.GlobalObject.WorkerPoolSize, utils.GlobalObject.PriorityStarvationLimit)
+	utils.GlobalObject.WorkerPoolSize = 1
+	utils.GlobalObject.PriorityStarvationLimit = 0
+
+	const (
+		highMsgID = 1
+		lowMsgID  = 2
+	)
+	s := NewServer("[zinx test]").(*Server)
+	handled := make(chan uint32, 2)
+	record := func(request ziface.IRequest) {
+		handled <- request.GetMsgID()
+	}
+	s.AddRouterWithPriority(highMsgID, &funcRouter{handle: record}, ziface.PriorityHigh)
+	s.AddRouterWithPriority(lowMsgID, &funcRouter{handle: record}, ziface.PriorityLow)
+
+	mh := s.MsgHandler.(*MsgHandle)
+	mh.StartWorkerPool()
+	defer mh.StopWorkerPool()
+	conn := NewConnection(s, nil, 0, mh)
+
+	for i := 0; i < 20; i++ {
+		//先放入请求再唤醒Worker,Worker被唤醒时两个队列中都有请求
+		mh.enqueue(0, &Request{conn: conn, msg: NewMsgPackage(lowMsgID, nil)})
+		mh.enqueue(0, &Request{conn: conn, msg: NewMsgPackage(highMsgID, nil)})
+		mh.notifyLane(0)
+		if first, second := <-handled, <-handled; first != highMsgID || second != lowMsgID {
+			t.Fatalf("round %d order = [%d %d], want [%d %d]", i, first, second, highMsgID, lowMsgID)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package znet
 
-import "zinx/ziface"
+import (
+	"errors"
+	"zinx/ziface"
+)
+
+//请求不是通过Call发送的,没有可以回复的Call
+var ErrNotCall = errors.New("request is not a call")
 
 type Request struct {
 	//已经和客户端建立好的连接
@@ -22,4 +28,19 @@ func (r *Request) GetData() []byte {
 //得到请求的消息ID
 func (r *Request) GetMsgID()  uint32 {
 	return r.msg.GetMsgId()
-}
\ No newline at end of file
+}
+
+//得到请求的seqID
+func (r *Request) GetSeqID() uint32 {
+	return r.msg.GetSeqID()
+}
+
+//回复对端的Call,对端的Call收到回复之后返回,不会交给对端的路由处理
+//请求不是Call时返回ErrNotCall,连接的封包格式无法携带seqID时返回ErrSeqNotSupported,
+//避免回复被对端当作普通的请求处理
+func (r *Request) Reply(data []byte) error {
+	if r.GetSeqID() == 0 {
+		return ErrNotCall
+	}
+	return r.conn.SendSeqMsg(r.GetMsgID(), r.GetSeqID()|SeqReplyFlag, data)
+}
@@ -0,0 +1,77 @@
+package znet
+
+import (
+	"fmt"
+	"zinx/ziface"
+)
+
+//函数形式的路由
+type funcRouter struct {
+	BaseRouter
+	handle func(request ziface.IRequest)
+}
+
+func (r *funcRouter) Handle(request ziface.IRequest) {
+	r.handle(request)
+}
+
+//路由分组,分组内的路由的msgID在[start, end]之内
+type RouterGroup struct {
+	msgHandler *MsgHandle
+	//父分组,顶层的分组为nil
+	parent *RouterGroup
+	//分组的msgID范围
+	start uint32
+	end   uint32
+	//只对分组内的路由生效的中间件
+	middlewares []ziface.Middleware
+}
+
+//创建一个路由分组
+func newRouterGroup(mh *MsgHandle, parent *RouterGroup, start, end uint32, middlewares []ziface.Middleware) *RouterGroup {
+	if start > end {
+		panic(fmt.Sprintf("invalid router group range [%d, %d]", start, end))
+	}
+	if parent != nil && (start < parent.start || end > parent.end) {
+		panic(fmt.Sprintf("router group range [%d, %d] out of parent range [%d, %d]", start, end, parent.start, parent.end))
+	}
+
+	return &RouterGroup{
+		msgHandler:  mh,
+		parent:      parent,
+		start:       start,
+		end:         end,
+		middlewares: middlewares,
+	}
+}
+
+//添加只对分组内的路由生效的中间件
+func (g *RouterGroup) Use(middlewares ...ziface.Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+	g.msgHandler.rebuildChains()
+}
+
+//在分组内注册路由
+func (g *RouterGroup) AddRouter(msgID uint32, router ziface.IRouter) {
+	if err := g.TryAddRouter(msgID, router); err != nil {
+		panic(err.Error())
+	}
+}
+
+//在分组内注册路由,msgID不在分组范围内时返回ErrMsgIDOutOfRange
+func (g *RouterGroup) TryAddRouter(msgID uint32, router ziface.IRouter) error {
+	if msgID < g.start || msgID > g.end {
+		return fmt.Errorf("%w [%d, %d], msgID = %d", ErrMsgIDOutOfRange, g.start, g.end, msgID)
+	}
+	return g.msgHandler.addRouter(msgID, router, g)
+}
+
+//在分组内注册一个处理函数作为路由
+func (g *RouterGroup) AddHandlerFunc(msgID uint32, handler func(request ziface.IRequest)) {
+	g.AddRouter(msgID, &funcRouter{handle: handler})
+}
+
+//创建一个子分组
+func (g *RouterGroup) Group(start, end uint32, middlewares ...ziface.Middleware) ziface.IRouterGroup {
+	return newRouterGroup(g.msgHandler, g, start, end, middlewares)
+}
@@ -0,0 +1,118 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//通过Reply回复Call,不回复msgID为3的请求
+type replyRouter struct {
+	BaseRouter
+}
+
+func (r *replyRouter) Handle(request ziface.IRequest) {
+	if request.GetMsgID() == 3 {
+		return
+	}
+	request.Reply(append([]byte("re: "), request.GetData()...))
+}
+
+func TestCall(t *testing.T) {
+	utils.GlobalObject.TcpPort = 7792
+	utils.GlobalObject.Host = "127.0.0.1"
+	s := NewServer("[zinx test]", WithPacket(NewSeqDataPack()))
+	s.AddRouter(1, &replyRouter{})
+	s.AddRouter(2, &replyRouter{})
+	s.AddRouter(3, &replyRouter{})
+	s.Start()
+	defer s.Stop()
+
+	c := NewClient("[zinx test client]", "127.0.0.1:7792", WithClientPacket(NewSeqDataPack()))
+	//回复只交给Call,不会交给客户端的路由
+	router := &recordRouter{msgs: make(chan string, 10)}
+	c.AddRouter(1, router)
+	c.AddRouter(2, router)
+	started := make(chan struct{}, 1)
+	c.SetOnConnStart(func(conn ziface.IConnection) {
+		started <- struct{}{}
+	})
+	c.Start()
+	defer c.Stop()
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("client not connected")
+	}
+
+	//并发的Call各自收到自己的回复
+	type result struct {
+		want string
+		got  ziface.IMessage
+		err  error
+	}
+	results := make(chan result, 20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			msgID := uint32(1 + i%2)
+			data := string(rune('a' + i))
+			reply, err := c.Call(context.Background(), msgID, []byte(data))
+			results <- result{want: "re: " + data, got: reply, err: err}
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if string(r.got.GetData()) != r.want {
+			t.Errorf("reply = %q, want %q", r.got.GetData(), r.want)
+		}
+	}
+	if len(router.msgs) != 0 {
+		t.Errorf("client router received %d replies", len(router.msgs))
+	}
+
+	//对端没有回复时,Call在ctx超时之后返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, 3, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call without reply err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCallSeqNotSupported(t *testing.T) {
+	s := newTestServer(t, 0)
+	serverConn, clientConn := newTCPConnPair(t)
+	defer clientConn.Close()
+	c := NewConnection(s, serverConn, 1, s.MsgHandler)
+	defer c.Stop()
+
+	if _, err := c.Call(context.Background(), 1, nil); err != ErrSeqNotSupported {
+		t.Errorf("Call err = %v, want %v", err, ErrSeqNotSupported)
+	}
+}
+
+//不是Call的请求,或者封包格式无法携带seqID时,Reply返回错误而不是发送普通消息
+func TestReplyNotCall(t *testing.T) {
+	s := newTestServer(t, 0)
+	serverConn, clientConn := newTCPConnPair(t)
+	defer clientConn.Close()
+	c := NewConnection(s, serverConn, 1, s.MsgHandler)
+	defer c.Stop()
+
+	request := &Request{conn: c, msg: NewMsgPackage(1, nil)}
+	if err := request.Reply(nil); err != ErrNotCall {
+		t.Errorf("Reply to plain request err = %v, want %v", err, ErrNotCall)
+	}
+
+	msg := NewMsgPackage(1, nil)
+	msg.SetSeqID(5)
+	request = &Request{conn: c, msg: msg}
+	if err := request.Reply(nil); err != ErrSeqNotSupported {
+		t.Errorf("Reply without seq packet err = %v, want %v", err, ErrSeqNotSupported)
+	}
+}
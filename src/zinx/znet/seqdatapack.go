@@ -0,0 +1,92 @@
+package znet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//seqID的最高位表示这是一个Call的回复,不会交给路由处理
+const SeqReplyFlag uint32 = 1 << 31
+
+//携带seqID的封包,拆包模块,用于RPC调用
+//|datalen|msgID|seqID|data
+type SeqDataPack struct {
+}
+
+//携带seqID的拆包封包实例的初始化方法
+func NewSeqDataPack() *SeqDataPack {
+	return &SeqDataPack{}
+}
+
+//获取包的头的长度方法
+func (dp *SeqDataPack) GetHeadLen() uint32 {
+	//Datalen uint32(4字节) + ID uint32(4字节) + SeqID uint32(4字节)
+	return 12
+}
+
+//包头中携带了seqID
+func (dp *SeqDataPack) HasSeqID() bool {
+	return true
+}
+
+//封包方法
+func (dp *SeqDataPack) Pack(msg ziface.IMessage) ([]byte, error) {
+	buf := make([]byte, dp.GetHeadLen()+msg.GetMsgLen())
+
+	binary.LittleEndian.PutUint32(buf[0:], msg.GetMsgLen())
+	binary.LittleEndian.PutUint32(buf[4:], msg.GetMsgId())
+	binary.LittleEndian.PutUint32(buf[8:], msg.GetSeqID())
+	copy(buf[dp.GetHeadLen():], msg.GetData())
+
+	return buf, nil
+}
+
+//拆包方法,只解析head信息,得到datalen,MsgID和SeqID
+func (dp *SeqDataPack) Unpack(binaryData []byte) (ziface.IMessage, error) {
+	if uint32(len(binaryData)) < dp.GetHeadLen() {
+		return nil, errors.New("seq msg head too short")
+	}
+
+	msg := &Message{
+		DataLen: binary.LittleEndian.Uint32(binaryData[0:]),
+		Id:      binary.LittleEndian.Uint32(binaryData[4:]),
+		SeqID:   binary.LittleEndian.Uint32(binaryData[8:]),
+	}
+
+	//判断datalen是否已经超出了我们允许的最大包长度
+	if utils.GlobalObject.MaxPackageSize > 0 && msg.DataLen > utils.GlobalObject.MaxPackageSize {
+		return nil, errors.New("too Large msg data recv!")
+	}
+
+	return msg, nil
+}
+
+//流式拆包方法
+func (dp *SeqDataPack) Decode(r *bufio.Reader) (ziface.IMessage, error) {
+	headData, err := r.Peek(int(dp.GetHeadLen()))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := dp.Unpack(headData)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Discard(len(headData)); err != nil {
+		return nil, err
+	}
+
+	//根据dataLen再读取Data
+	if msg.GetMsgLen() > 0 {
+		data := make([]byte, msg.GetMsgLen())
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		msg.SetData(data)
+	}
+
+	return msg, nil
+}
@@ -1,8 +1,18 @@
 package znet
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"zinx/utils"
 	"zinx/ziface"
 )
@@ -11,7 +21,7 @@ import (
 type Server struct {
 	//服务器的名称
 	Name string
-	//服务器绑定的ip版本
+	//服务器监听的网络类型,tcp4,tcp6,tcp或unix
 	IPVersion string
 	//服务器监听的ip
 	IP string
@@ -21,10 +31,51 @@ type Server struct {
 	MsgHandler ziface.IMsgHandle
 	//该server的连接管理器
 	ConnMgr ziface.IConnManager
+	//该server所有连接使用的封包拆包模块
+	packet ziface.IDataPack
+	//该server的TLS配置,为nil时不使用TLS
+	tlsConfig *tls.Config
 	//该Server创建连接之后自动调用Hook函数--OnConnStart
 	OnConnStart func(conn ziface.IConnection)
 	//该Server销毁连接之前自动调用Hook函数--OnConnStop
-	OnConnStop func(conn ziface.IConnection)
+	OnConnStop func(conn ziface.IConnection, closeErr *ziface.CloseError)
+	//该Server检测到连接空闲超时之后调用Hook函数--OnConnIdle
+	OnConnIdle func(conn ziface.IConnection)
+
+	//当前Server的监听器,可以通过WithListener传入
+	listener net.Listener
+	//当前Server的WebSocket服务,没有开启WebSocket时为nil
+	wsServer *http.Server
+	//当前Server的UDP监听器,没有开启UDP时为nil
+	udpListener net.Listener
+	//保护listener,wsServer和udpListener的锁
+	listenerLock sync.Mutex
+	//下一个连接的ID,TCP,WebSocket和UDP的连接共用,只能通过atomic读写
+	nextConnID uint32
+	//告知Server已经开始关闭的channel
+	exitChan chan struct{}
+	//Server的所有Goroutine都已经退出之后关闭的channel
+	doneChan chan struct{}
+	//保证关闭流程只执行一次
+	shutdownOnce sync.Once
+	//追踪Accept的Goroutine
+	acceptWg sync.WaitGroup
+	//追踪所有连接的Goroutine
+	connWg sync.WaitGroup
+	//Server启动失败时,通知Serve的channel
+	errChan chan error
+
+	//服务器启动之后执行的任务
+	startupTasks []func(ctx context.Context)
+	//服务器关闭时执行的任务
+	shutdownTasks []func() error
+	//启动任务的ctx,服务器关闭时被取消
+	taskCtx    context.Context
+	taskCancel context.CancelFunc
+	//追踪启动任务的Goroutine
+	taskWg sync.WaitGroup
+	//关闭任务返回的第一个错误
+	shutdownErr error
 }
 
 //启动服务器
@@ -36,71 +87,325 @@ func (s *Server) Start() {
 		utils.GlobalObject.MaxConn,
 		utils.GlobalObject.MaxPackageSize)
 
+	s.acceptWg.Add(1)
 	go func() {
+		defer s.acceptWg.Done()
+
 		//0开启消息队列及Worker工作池
 		s.MsgHandler.StartWorkerPool()
 
-		//1获取一个TCP的Addr
-		addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
-		if err != nil {
-			fmt.Println("resolve tcp addr error: ", err)
+		//加载zinx.json中配置的TLS证书
+		if err := s.loadTLSConfig(); err != nil {
+			fmt.Println("load tls config error: ", err)
+			s.errChan <- err
 			return
 		}
 
-		//2监听服务器的地址
-		listener, err := net.ListenTCP(s.IPVersion, addr)
+		//1监听服务器的地址,传入了监听器时直接使用
+		listener, err := s.listen()
 		if err != nil {
 			fmt.Println("listen: ", s.IPVersion, " err ", err)
+			s.errChan <- err
+			return
+		}
+
+		//如果在监听的过程中Server已经开始关闭,则直接关闭监听器
+		if !s.setListener(listener) {
+			listener.Close()
 			return
 		}
 
-		fmt.Println("start Zinx server succ, ", s.Name, " succ, Listening...")
-		var cid uint32
-		cid = 0
+		fmt.Println("start Zinx server succ, ", s.Name, " succ, Listening at ", listener.Addr())
+
+		//开启WebSocket服务,和TCP共用路由和连接管理
+		if utils.GlobalObject.WsPort > 0 {
+			if err := s.startWebSocket(); err != nil {
+				fmt.Println("start websocket error: ", err)
+				s.errChan <- err
+				return
+			}
+		}
+
+		//开启UDP服务,每个客户端的会话作为一个连接加入Server
+		if utils.GlobalObject.UdpPort > 0 {
+			if err := s.startUDP(); err != nil {
+				fmt.Println("start udp error: ", err)
+				s.errChan <- err
+				return
+			}
+		}
+
+		//开启服务器启动之后的任务
+		s.runStartupTasks()
 
 		//3阻塞等待客户端连接,处理客户端连接业务(读写)
 		for {
 			//如果有客户端连接过来,阻塞会返回
-			conn, err := listener.AcceptTCP()
+			conn, err := listener.Accept()
 			if err != nil {
+				//监听器已经被Shutdown关闭,退出Accept循环
+				if s.isShuttingDown() {
+					return
+				}
 				fmt.Println("Accept error: ", err)
 				continue
 			}
+			//开启了TLS时,在连接之上建立TLS连接,握手在连接Start时进行
+			s.handleConn(s.wrapTLS(conn))
+		}
+	}()
+}
 
-			//设置最大连接个数的判断,如果超过最大连接,那么则关闭此新的连接
-			if s.ConnMgr.Len() >= utils.GlobalObject.MaxConn {
-				//TODO 给客户端响应一个超出最大连接的错误包
-				fmt.Println("Too Many Connections MaxConn = ", utils.GlobalObject.MaxConn)
-				conn.Close()
-				continue
-			}
+//处理一个新建立的连接,TCP,WebSocket和UDP的连接都通过这里加入Server
+func (s *Server) handleConn(conn net.Conn) {
+	//设置最大连接个数的判断,如果超过最大连接,那么给客户端响应一个超出最大连接的错误包之后关闭此新的连接
+	if s.ConnMgr.Len() >= utils.GlobalObject.MaxConn {
+		fmt.Println("Too Many Connections MaxConn = ", utils.GlobalObject.MaxConn)
+		s.connWg.Add(1)
+		go func() {
+			defer s.connWg.Done()
+			s.rejectConn(conn)
+		}()
+		return
+	}
 
-			//将处理新连接的业务方法和conn绑定,得到连接模块
-			dealConn := NewConnection(s, conn, cid, s.MsgHandler)
-			cid++
+	//将处理新连接的业务方法和conn绑定,得到连接模块
+	cid := atomic.AddUint32(&s.nextConnID, 1) - 1
+	dealConn := NewConnection(s, conn, cid, s.MsgHandler)
 
-			//启动当前的连接业务处理
-			go dealConn.Start()
-		}
+	//启动当前的连接业务处理
+	s.connWg.Add(1)
+	go func() {
+		defer s.connWg.Done()
+		dealConn.Start()
+		dealConn.wait()
 	}()
 }
 
 //停止服务器
 func (s *Server) Stop() {
+	if err := s.Shutdown(context.Background()); err != nil {
+		fmt.Println("[STOP] Zinx server shutdown err: ", err)
+	}
+}
+
+//优雅关闭服务器
+//停止接收新的连接和新的请求,等待TaskQueue中剩余的请求处理完毕并把回复写给客户端之后,再关闭所有连接
+//所有Goroutine退出之后返回nil,如果ctx先结束,则返回ctx.Err(),关闭流程仍会在后台继续进行
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		close(s.exitChan)
+		go s.shutdown(ctx)
+	})
+
+	select {
+	case <-s.doneChan:
+		return s.shutdownErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//关闭服务器的具体流程,ctx结束之后不再等待Writer写完剩余的回复
+func (s *Server) shutdown(ctx context.Context) {
 	//将一些服务器的资源,状态或者一些已经开辟的连接信息 进行停止或者回收
 	fmt.Println("[STOP] Zinx server name ", s.Name)
+
+	//1 关闭监听器,等待Accept的Goroutine退出,保证不会再有新的连接加入
+	//UDP的会话依赖监听的套接字发送回复,只停止接受新的会话,连接都停止之后再关闭
+	s.listenerLock.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.wsServer != nil {
+		s.wsServer.Close()
+	}
+	if l, ok := s.udpListener.(*udpListener); ok {
+		l.stopAccept()
+	}
+	s.listenerLock.Unlock()
+	s.acceptWg.Wait()
+
+	//通知启动任务退出
+	s.taskCancel()
+
+	//2 停止所有连接的Reader,不再接收新的请求,连接保持打开以便发送剩余的回复
+	s.rangeConns(func(conn *Connection) {
+		conn.stopReading()
+	})
+	s.rangeConns(func(conn *Connection) {
+		conn.waitReader()
+	})
+
+	//3 此时已经没有Reader再投递消息,等待Worker把TaskQueue中剩余的请求处理完毕
+	s.MsgHandler.StopWorkerPool()
+	//没有开启工作池时,等待每个连接自己处理消息的Goroutine
+	s.rangeConns(func(conn *Connection) {
+		conn.handlerWg.Wait()
+	})
+
+	//4 Writer把剩余的回复写给客户端,最多等待shutdownFlushTimeout
+	//不读取数据的客户端会让Writer一直阻塞,超时之后由ClearConn关闭连接
+	flushTimer := time.NewTimer(shutdownFlushTimeout)
+	flushExpired := false
+	s.rangeConns(func(conn *Connection) {
+		writerDone := conn.flush()
+		//已经超时,只通知剩余连接的Writer,不再等待
+		if flushExpired {
+			return
+		}
+		select {
+		case <-writerDone:
+		case <-flushTimer.C:
+			flushExpired = true
+		case <-ctx.Done():
+			flushExpired = true
+		}
+	})
+	flushTimer.Stop()
+
+	//5 停止所有的连接(会调用每个连接的OnConnStop),并等待连接的读写Goroutine退出
 	s.ConnMgr.ClearConn()
+	s.connWg.Wait()
+	s.listenerLock.Lock()
+	if s.udpListener != nil {
+		s.udpListener.Close()
+	}
+	s.listenerLock.Unlock()
+
+	//6 连接都已经关闭,阻塞在发送消息上的启动任务会返回,等待它们结束
+	s.taskWg.Wait()
+
+	//7 执行开发者注册的关闭任务
+	s.runShutdownTasks()
+
+	fmt.Println("[STOP] Zinx server ", s.Name, " is stopped")
+	close(s.doneChan)
+}
+
+//关闭服务器时等待Writer写完剩余回复的最长时间
+const shutdownFlushTimeout = 2 * time.Second
+
+//遍历Server当前所有的连接
+func (s *Server) rangeConns(fn func(conn *Connection)) {
+	s.ConnMgr.Range(func(conn ziface.IConnection) {
+		if c, ok := conn.(*Connection); ok {
+			fn(c)
+		}
+	})
+}
+
+//创建Server的监听器,通过WithListener传入了监听器时直接返回
+func (s *Server) listen() (net.Listener, error) {
+	s.listenerLock.Lock()
+	listener := s.listener
+	s.listenerLock.Unlock()
+	if listener != nil {
+		return listener, nil
+	}
+
+	//Unix socket监听的是文件路径
+	if s.IPVersion == "unix" {
+		return net.Listen(s.IPVersion, utils.GlobalObject.UnixSocket)
+	}
+	//JoinHostPort可以正确处理IPv6的地址
+	return net.Listen(s.IPVersion, net.JoinHostPort(s.IP, strconv.Itoa(s.Port)))
+}
+
+//记录当前的监听器,如果Server已经开始关闭则返回false
+func (s *Server) setListener(listener net.Listener) bool {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+
+	if s.isShuttingDown() {
+		return false
+	}
+	s.listener = listener
+	return true
+}
+
+//Server是否已经开始关闭
+func (s *Server) isShuttingDown() bool {
+	select {
+	case <-s.exitChan:
+		return true
+	default:
+		return false
+	}
 }
 
 //运行服务器
-func (s *Server) Serve() {
+func (s *Server) Serve() error {
 	//启动server的服务功能
 	s.Start()
 
-	// TODO 做一些启动服务器之后的额外业务
+	//监听退出信号
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	//阻塞状态,直到收到退出信号,启动失败或者Server被其他地方关闭
+	var startErr error
+	select {
+	case sig := <-sigChan:
+		fmt.Println("[Zinx] receive signal ", sig, ", server is shutting down ...")
+	case startErr = <-s.errChan:
+	case <-s.exitChan:
+	}
+
+	ctx := context.Background()
+	if utils.GlobalObject.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(utils.GlobalObject.ShutdownTimeout)*time.Second)
+		defer cancel()
+	}
+	err := s.Shutdown(ctx)
+	if startErr != nil {
+		return startErr
+	}
+	return err
+}
+
+//添加服务器启动之后执行的任务
+func (s *Server) AddStartupTask(task func(ctx context.Context)) {
+	s.startupTasks = append(s.startupTasks, task)
+}
+
+//添加服务器关闭时执行的任务
+func (s *Server) AddShutdownTask(task func() error) {
+	s.shutdownTasks = append(s.shutdownTasks, task)
+}
+
+//开启所有的启动任务,每个任务一个Goroutine
+func (s *Server) runStartupTasks() {
+	//开启心跳检测
+	if utils.GlobalObject.HeartbeatInterval > 0 {
+		s.taskWg.Add(1)
+		go func() {
+			defer s.taskWg.Done()
+			s.startHeartbeat(s.taskCtx)
+		}()
+	}
 
-	//阻塞状态
-	select {}
+	for _, task := range s.startupTasks {
+		s.taskWg.Add(1)
+		go func(task func(ctx context.Context)) {
+			defer s.taskWg.Done()
+			task(s.taskCtx)
+		}(task)
+	}
+}
+
+//按添加的顺序执行所有的关闭任务,记录第一个错误
+func (s *Server) runShutdownTasks() {
+	for _, task := range s.shutdownTasks {
+		if err := task(); err != nil {
+			fmt.Println("[STOP] shutdown task err: ", err)
+			if s.shutdownErr == nil {
+				s.shutdownErr = err
+			}
+		}
+	}
 }
 
 //路由功能:给当前的服务注册一个路由方法,供客户端的连接处理使用
@@ -109,19 +414,84 @@ func (s *Server) AddRouter(msgID uint32, router ziface.IRouter) {
 	fmt.Println("Add Router Succ!!")
 }
 
+//注册路由,msgID已经注册过时返回错误
+func (s *Server) TryAddRouter(msgID uint32, router ziface.IRouter) error {
+	return s.MsgHandler.TryAddRouter(msgID, router)
+}
+
+//注册路由并设置消息的优先级
+func (s *Server) AddRouterWithPriority(msgID uint32, router ziface.IRouter, priority ziface.Priority) {
+	s.MsgHandler.AddRouterWithPriority(msgID, router, priority)
+}
+
+//设置消息的优先级
+func (s *Server) SetPriority(msgID uint32, priority ziface.Priority) {
+	s.MsgHandler.SetPriority(msgID, priority)
+}
+
+//注册一个处理函数作为路由
+func (s *Server) AddHandlerFunc(msgID uint32, handler func(request ziface.IRequest)) {
+	s.MsgHandler.AddHandlerFunc(msgID, handler)
+}
+
+//设置把请求分配给Worker的策略
+func (s *Server) SetDispatcher(dispatcher ziface.IDispatcher) {
+	s.MsgHandler.SetDispatcher(dispatcher)
+}
+
+//设置兜底路由,处理所有没有注册路由的消息
+func (s *Server) SetNotFoundRouter(router ziface.IRouter) {
+	s.MsgHandler.SetNotFoundRouter(router)
+}
+
+//创建一个路由分组
+func (s *Server) Group(start, end uint32, middlewares ...ziface.Middleware) ziface.IRouterGroup {
+	return s.MsgHandler.Group(start, end, middlewares...)
+}
+
+//添加对所有消息生效的中间件
+func (s *Server) Use(middlewares ...ziface.Middleware) {
+	s.MsgHandler.Use(middlewares...)
+}
+
+//添加只对msgID生效的中间件
+func (s *Server) UseFor(msgID uint32, middlewares ...ziface.Middleware) {
+	s.MsgHandler.UseFor(msgID, middlewares...)
+}
+
 func (s *Server) GetConnMgr() ziface.IConnManager {
 	return s.ConnMgr
 }
 
+//设置当前server所有连接使用的封包拆包模块
+func (s *Server) SetPacket(packet ziface.IDataPack) {
+	s.packet = packet
+}
+
+//获取当前server所有连接使用的封包拆包模块
+func (s *Server) GetPacket() ziface.IDataPack {
+	return s.packet
+}
+
 //初始化Server模块的方法
-func NewServer(name string) ziface.IServer {
+func NewServer(name string, opts ...Option) ziface.IServer {
 	s := &Server{
 		Name:       utils.GlobalObject.Name,
-		IPVersion:  "tcp4",
+		IPVersion:  utils.GlobalObject.Network,
 		IP:         utils.GlobalObject.Host,
 		Port:       utils.GlobalObject.TcpPort,
 		MsgHandler: NewMsgHandle(),
 		ConnMgr:    NewConnManager(),
+		packet:     NewDataPack(),
+		exitChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+		errChan:    make(chan error, 1),
+	}
+	s.taskCtx, s.taskCancel = context.WithCancel(context.Background())
+
+	//应用开发者传入的自定义选项
+	for _, opt := range opts {
+		opt(s)
 	}
 
 	return s
@@ -133,10 +503,20 @@ func (s *Server) SetOnConnStart(hookFunc func(connection ziface.IConnection)) {
 }
 
 //注册OnConnStop钩子函数的方法
-func (s *Server) SetOnConnStop(hookFunc func(connection ziface.IConnection)) {
+func (s *Server) SetOnConnStop(hookFunc func(connection ziface.IConnection, closeErr *ziface.CloseError)) {
 	s.OnConnStop = hookFunc
 }
 
+//注册OnConnIdle钩子函数的方法
+func (s *Server) SetOnConnIdle(hookFunc func(connection ziface.IConnection)) {
+	s.OnConnIdle = hookFunc
+}
+
+//注册处理消息发生panic时调用的Hook函数
+func (s *Server) SetOnPanic(hookFunc func(request ziface.IRequest, recovered interface{}, stack []byte)) {
+	s.MsgHandler.SetOnPanic(hookFunc)
+}
+
 //调用OnConnStart钩子函数的方法
 func (s *Server) CallOnConnStart(conn ziface.IConnection) {
 	if s.OnConnStart!=nil {
@@ -149,6 +529,19 @@ func (s *Server) CallOnConnStart(conn ziface.IConnection) {
 func (s *Server) CallOnConnStop(conn ziface.IConnection) {
 	if s.OnConnStop!=nil{
 		fmt.Println("----> Call OnConnStop() ...")
-		s.OnConnStop(conn)
+		s.OnConnStop(conn, conn.GetCloseError())
+	}
+}
+
+//调用OnConnIdle钩子函数的方法
+func (s *Server) CallOnConnIdle(conn ziface.IConnection) {
+	if s.OnConnIdle != nil {
+		fmt.Println("----> Call OnConnIdle() ...")
+		s.OnConnIdle(conn)
+		return
 	}
+
+	//没有注册OnConnIdle,直接关闭空闲的连接
+	fmt.Println("ConnID = ", conn.GetConnID(), " is idle too long, stop it")
+	conn.StopWithReason(ziface.CloseReasonTimeout, ErrIdleTimeout)
 }
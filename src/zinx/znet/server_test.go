@@ -0,0 +1,530 @@
+package znet
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//测试用的慢速路由,用来模拟TaskQueue中尚未处理完的请求
+type slowRouter struct {
+	BaseRouter
+	handled int32
+}
+
+func (r *slowRouter) Handle(request ziface.IRequest) {
+	time.Sleep(50 * time.Millisecond)
+	atomic.AddInt32(&r.handled, 1)
+}
+
+//创建一个监听在指定端口的测试Server
+func newTestServer(t *testing.T, port int) *Server {
+	utils.GlobalObject.TcpPort = port
+	utils.GlobalObject.Host = "127.0.0.1"
+	return NewServer("[zinx test]").(*Server)
+}
+
+//客户端连接测试Server,等待Server的监听器就绪
+func dialTestServer(t *testing.T, port int) net.Conn {
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return conn
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("dial test server %s failed", addr)
+	return nil
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := newTestServer(t, 7781)
+	router := &slowRouter{}
+	s.AddRouter(1, router)
+
+	var stopped int32
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		if closeErr.Reason != ziface.CloseReasonServerShutdown {
+			t.Errorf("close reason = %v, want %v", closeErr.Reason, ziface.CloseReasonServerShutdown)
+		}
+		atomic.AddInt32(&stopped, 1)
+	})
+	s.Start()
+
+	conn := dialTestServer(t, 7781)
+	defer conn.Close()
+
+	//一次性发送多个消息,让它们堆积在Worker的TaskQueue中
+	dp := NewDataPack()
+	var data []byte
+	for i := 0; i < 5; i++ {
+		msg, _ := dp.Pack(NewMsgPackage(1, []byte("zinx")))
+		data = append(data, msg...)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown err: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&router.handled); n != 5 {
+		t.Errorf("handled %d requests, want 5", n)
+	}
+	if n := atomic.LoadInt32(&stopped); n != 1 {
+		t.Errorf("OnConnStop called %d times, want 1", n)
+	}
+	if s.GetConnMgr().Len() != 0 {
+		t.Errorf("ConnMgr still has %d connections", s.GetConnMgr().Len())
+	}
+
+	//Shutdown之后不再接收新的连接
+	if c, err := net.DialTimeout("tcp", "127.0.0.1:7781", 200*time.Millisecond); err == nil {
+		c.Close()
+		t.Error("server still accepting after Shutdown")
+	}
+
+	//重复调用Shutdown直接返回
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("second Shutdown err: %v", err)
+	}
+}
+
+//关闭服务器时TaskQueue中剩余的请求仍然可以回复客户端,OnConnStop在请求处理完之后调用
+func TestServerShutdownReply(t *testing.T) {
+	s := newTestServer(t, 7796)
+	var handled, sendErrs int32
+	s.AddHandlerFunc(1, func(request ziface.IRequest) {
+		time.Sleep(50 * time.Millisecond)
+		if err := request.GetConnection().SendMsg(1, request.GetData()); err != nil {
+			atomic.AddInt32(&sendErrs, 1)
+		}
+		atomic.AddInt32(&handled, 1)
+	})
+	var handledAtStop int32 = -1
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		atomic.StoreInt32(&handledAtStop, atomic.LoadInt32(&handled))
+	})
+	s.Start()
+
+	conn := dialTestServer(t, 7796)
+	defer conn.Close()
+
+	dp := NewDataPack()
+	var data []byte
+	for i := 0; i < 5; i++ {
+		msg, _ := dp.Pack(NewMsgPackage(1, []byte{byte(i)}))
+		data = append(data, msg...)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown err: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&sendErrs); n != 0 {
+		t.Errorf("%d replies failed during shutdown", n)
+	}
+	if n := atomic.LoadInt32(&handledAtStop); n != 5 {
+		t.Errorf("OnConnStop called after %d requests handled, want 5", n)
+	}
+
+	//客户端收到所有的回复之后连接被关闭
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 5; i++ {
+		msg, err := readMessage(reader, dp)
+		if err != nil {
+			t.Fatalf("read reply %d err: %v", i, err)
+		}
+		if msg.GetData()[0] != byte(i) {
+			t.Errorf("reply %d data = %v", i, msg.GetData())
+		}
+	}
+	if _, err := readMessage(reader, dp); err != io.EOF {
+		t.Errorf("read after replies err = %v, want EOF", err)
+	}
+}
+
+//启动任务阻塞在给不读取数据的客户端发送消息上,不能让关闭流程一直等待
+func TestServerShutdownStalledClient(t *testing.T) {
+	s := newTestServer(t, 7797)
+	s.AddStartupTask(func(ctx context.Context) {
+		data := make([]byte, 1<<20)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			s.GetConnMgr().Range(func(conn ziface.IConnection) {
+				conn.SendMsg(1, data)
+			})
+		}
+	})
+	s.Start()
+
+	//客户端从不读取数据
+	conn := dialTestServer(t, 7797)
+	defer conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > shutdownFlushTimeout+time.Second {
+		t.Errorf("Shutdown took %v", elapsed)
+	}
+}
+
+//关闭连接时OnConnStop给其他还在线的连接发送消息,不能阻塞关闭流程
+func TestServerShutdownNotifyOthers(t *testing.T) {
+	s := newTestServer(t, 7800)
+	//业务自己维护的在线玩家,ClearConn时ConnMgr中已经没有连接了
+	var lock sync.Mutex
+	players := make(map[uint32]ziface.IConnection)
+	s.SetOnConnStart(func(conn ziface.IConnection) {
+		lock.Lock()
+		players[conn.GetConnID()] = conn
+		lock.Unlock()
+	})
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		lock.Lock()
+		delete(players, conn.GetConnID())
+		others := make([]ziface.IConnection, 0, len(players))
+		for _, other := range players {
+			others = append(others, other)
+		}
+		lock.Unlock()
+		//通知其他玩家下线
+		for _, other := range others {
+			other.SendMsg(2, []byte("offline"))
+		}
+	})
+	var shutdownTaskRan int32
+	s.AddShutdownTask(func() error {
+		atomic.StoreInt32(&shutdownTaskRan, 1)
+		return nil
+	})
+	s.Start()
+
+	for i := 0; i < 2; i++ {
+		conn := dialTestServer(t, 7800)
+		defer conn.Close()
+	}
+	if !waitFor(func() bool { return s.GetConnMgr().Len() == 2 }) {
+		t.Fatalf("conn num = %d, want 2", s.GetConnMgr().Len())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown err: %v", err)
+	}
+	if atomic.LoadInt32(&shutdownTaskRan) != 1 {
+		t.Error("shutdown task did not run")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s := newTestServer(t, 7782)
+	s.AddRouter(1, &slowRouter{})
+	s.Start()
+
+	conn := dialTestServer(t, 7782)
+	defer conn.Close()
+
+	dp := NewDataPack()
+	var data []byte
+	for i := 0; i < 20; i++ {
+		msg, _ := dp.Pack(NewMsgPackage(1, []byte("zinx")))
+		data = append(data, msg...)
+	}
+	conn.Write(data)
+	time.Sleep(100 * time.Millisecond)
+
+	//超时时间不足以处理完队列中的请求
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	//关闭流程仍在后台继续进行
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown err: %v", err)
+	}
+}
+
+func TestServerTasks(t *testing.T) {
+	s := newTestServer(t, 7783)
+
+	started := make(chan struct{})
+	var startupExited int32
+	s.AddStartupTask(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		atomic.StoreInt32(&startupExited, 1)
+	})
+
+	var order []int
+	taskErr := errors.New("flush failed")
+	s.AddShutdownTask(func() error {
+		//关闭任务执行时,启动任务已经退出
+		if atomic.LoadInt32(&startupExited) != 1 {
+			t.Error("startup task still running when shutdown task runs")
+		}
+		order = append(order, 1)
+		return taskErr
+	})
+	s.AddShutdownTask(func() error {
+		order = append(order, 2)
+		return nil
+	})
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve()
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("startup task not started")
+	}
+
+	//在其他地方关闭Server,Serve也会返回
+	if err := s.Shutdown(context.Background()); err != taskErr {
+		t.Fatalf("Shutdown err = %v, want %v", err, taskErr)
+	}
+	select {
+	case err := <-serveErr:
+		if err != taskErr {
+			t.Fatalf("Serve err = %v, want %v", err, taskErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve not return after Shutdown")
+	}
+
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Errorf("shutdown tasks order = %v, want [1 2]", order)
+	}
+}
+
+//测试用的封包格式: 大端序 2字节长度|2字节消息ID|data
+type shortHeadPack struct{}
+
+func (p *shortHeadPack) GetHeadLen() uint32 {
+	return 4
+}
+
+func (p *shortHeadPack) Pack(msg ziface.IMessage) ([]byte, error) {
+	buf := make([]byte, 4+len(msg.GetData()))
+	binary.BigEndian.PutUint16(buf[0:], uint16(msg.GetMsgLen()))
+	binary.BigEndian.PutUint16(buf[2:], uint16(msg.GetMsgId()))
+	copy(buf[4:], msg.GetData())
+	return buf, nil
+}
+
+func (p *shortHeadPack) Unpack(head []byte) (ziface.IMessage, error) {
+	return &Message{
+		DataLen: uint32(binary.BigEndian.Uint16(head[0:])),
+		Id:      uint32(binary.BigEndian.Uint16(head[2:])),
+	}, nil
+}
+
+//原样回写收到的消息
+type echoRouter struct {
+	BaseRouter
+}
+
+func (r *echoRouter) Handle(request ziface.IRequest) {
+	request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+func TestServerCustomPacket(t *testing.T) {
+	utils.GlobalObject.TcpPort = 7784
+	utils.GlobalObject.Host = "127.0.0.1"
+	pack := &shortHeadPack{}
+	s := NewServer("[zinx test]", WithPacket(pack))
+	s.AddRouter(7, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialTestServer(t, 7784)
+	defer conn.Close()
+
+	data, _ := pack.Pack(NewMsgPackage(7, []byte("hello")))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	head := make([]byte, pack.GetHeadLen())
+	if _, err := io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := pack.Unpack(head)
+	body := make([]byte, msg.GetMsgLen())
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgId() != 7 || string(body) != "hello" {
+		t.Errorf("recv msgID = %d, data = %q, want 7, \"hello\"", msg.GetMsgId(), body)
+	}
+}
+
+func TestServerFull(t *testing.T) {
+	oldMax, oldMsgID, oldRetry := utils.GlobalObject.MaxConn, utils.GlobalObject.ServerFullMsgID, utils.GlobalObject.ServerFullRetryAfter
+	defer func() {
+		utils.GlobalObject.MaxConn = oldMax
+		utils.GlobalObject.ServerFullMsgID = oldMsgID
+		utils.GlobalObject.ServerFullRetryAfter = oldRetry
+	}()
+	utils.GlobalObject.MaxConn = 1
+	utils.GlobalObject.ServerFullMsgID = 500
+	utils.GlobalObject.ServerFullRetryAfter = 30
+
+	s := newTestServer(t, 7785)
+	s.Start()
+	defer s.Stop()
+
+	first := dialTestServer(t, 7785)
+	defer first.Close()
+	//等待第一个连接加入ConnMgr
+	for i := 0; i < 50 && s.GetConnMgr().Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second := dialTestServer(t, 7785)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	msg, err := readMessage(bufio.NewReader(second), NewDataPack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgId() != 500 {
+		t.Fatalf("msgID = %d, want 500", msg.GetMsgId())
+	}
+	var full ServerFullMsg
+	if err := json.Unmarshal(msg.GetData(), &full); err != nil {
+		t.Fatal(err)
+	}
+	if full.RetryAfter != 30 || full.MaxConn != 1 || full.Msg == "" {
+		t.Errorf("server full msg = %+v", full)
+	}
+
+	//之后连接被服务端关闭
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("rejected connection not closed")
+	}
+}
+
+//回复当前连接底层net.Conn的类型
+type connTypeRouter struct {
+	BaseRouter
+}
+
+func (r *connTypeRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	request.GetConnection().SendMsg(request.GetMsgID(), []byte(fmt.Sprintf("%T %v", conn.GetConn(), conn.GetTCPConnection() != nil)))
+}
+
+//发送一个消息并读取回复
+func roundTrip(t *testing.T, conn net.Conn, msgID uint32) string {
+	dp := NewDataPack()
+	data, _ := dp.Pack(NewMsgPackage(msgID, nil))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := readMessage(bufio.NewReader(conn), dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg.GetData())
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	oldNetwork, oldSocket := utils.GlobalObject.Network, utils.GlobalObject.UnixSocket
+	defer func() {
+		utils.GlobalObject.Network = oldNetwork
+		utils.GlobalObject.UnixSocket = oldSocket
+	}()
+	utils.GlobalObject.Network = "unix"
+	utils.GlobalObject.UnixSocket = t.TempDir() + "/zinx.sock"
+
+	s := NewServer("[zinx test]")
+	s.AddRouter(1, &connTypeRouter{})
+	s.Start()
+	defer s.Stop()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", utils.GlobalObject.UnixSocket); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := roundTrip(t, conn, 1); got != "*net.UnixConn false" {
+		t.Errorf("conn type = %q", got)
+	}
+}
+
+func TestServerWithListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("[zinx test]", WithListener(listener))
+	s.AddRouter(1, &connTypeRouter{})
+	s.Start()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := roundTrip(t, conn, 1); got != "*net.TCPConn true" {
+		t.Errorf("conn type = %q", got)
+	}
+
+	//Server关闭时同时关闭传入的监听器
+	s.Stop()
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("listener not closed after Stop")
+	}
+}
@@ -0,0 +1,53 @@
+package znet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+	"zinx/utils"
+)
+
+//连接数达到MaxConn时,回复给新连接的消息内容(JSON编码)
+type ServerFullMsg struct {
+	Msg        string `json:"msg"`                   //提示信息
+	RetryAfter int    `json:"retry_after,omitempty"` //建议客户端多久之后重新连接(秒)
+	ConnNum    int    `json:"conn_num"`              //当前的连接数
+	MaxConn    int    `json:"max_conn"`              //允许的最大连接数
+}
+
+//写给客户端服务器已满的消息的最长时间
+const serverFullWriteTimeout = time.Second
+
+//拒绝一个超出最大连接数的连接
+//配置了ServerFullMsgID时,先使用当前的封包模块回复一个ServerFullMsg,再关闭连接
+func (s *Server) rejectConn(conn net.Conn) {
+	defer conn.Close()
+
+	if utils.GlobalObject.ServerFullMsgID == 0 {
+		return
+	}
+
+	data, err := json.Marshal(&ServerFullMsg{
+		Msg:        utils.GlobalObject.ServerFullMsg,
+		RetryAfter: utils.GlobalObject.ServerFullRetryAfter,
+		ConnNum:    s.ConnMgr.Len(),
+		MaxConn:    utils.GlobalObject.MaxConn,
+	})
+	if err != nil {
+		fmt.Println("marshal server full msg err: ", err)
+		return
+	}
+
+	binaryMsg, err := s.packet.Pack(NewMsgPackage(utils.GlobalObject.ServerFullMsgID, data))
+	if err != nil {
+		fmt.Println("pack server full msg err: ", err)
+		return
+	}
+
+	//不能让一个不读数据的客户端阻塞住(TLS连接在Write时还需要完成握手)
+	conn.SetDeadline(time.Now().Add(serverFullWriteTimeout))
+	if _, err := conn.Write(binaryMsg); err != nil {
+		fmt.Println("send server full msg to ", conn.RemoteAddr(), " err: ", err)
+	}
+}
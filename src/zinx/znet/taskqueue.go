@@ -0,0 +1,119 @@
+package znet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//TaskQueueOverflowReject策略下回复给客户端的错误码
+const TaskQueueFullCode = 503
+
+//TaskQueue满时,回复给客户端的消息内容(JSON编码)
+type TaskQueueFullMsg struct {
+	Code  int    `json:"code"`   //错误码,固定为TaskQueueFullCode
+	MsgID uint32 `json:"msg_id"` //被拒绝的请求的消息ID
+	Msg   string `json:"msg"`    //提示信息
+}
+
+//一个Worker的计数器,只能通过atomic读写
+type workerCounter struct {
+	dispatched uint64
+	processed  uint64
+	dropped    uint64
+	rejected   uint64
+}
+
+//将请求放入Worker的TaskQueue,队列满时按照TaskQueueOverflow策略处理,避免一个Worker的积压阻塞所有连接的Reader
+func (mh *MsgHandle) enqueue(workerID uint32, request ziface.IRequest) {
+	//按照消息的优先级放入对应的队列
+	taskQueue := mh.laneQueue(workerID, request)
+	counter := &mh.workerCounters[workerID]
+
+	//先尝试直接放入队列
+	select {
+	case taskQueue <- request:
+		atomic.AddUint64(&counter.dispatched, 1)
+		return
+	default:
+	}
+
+	switch utils.GlobalObject.TaskQueueOverflow {
+	case utils.TaskQueueOverflowDropNewest:
+		fmt.Println("TaskQueue of WorkerID = ", workerID, " is full, drop msgID = ", request.GetMsgID())
+		atomic.AddUint64(&counter.dropped, 1)
+	case utils.TaskQueueOverflowDropOldest:
+		//取出队列中最早的请求丢弃,Worker可能同时取走了请求,所以放入仍然是非阻塞的
+		select {
+		case oldest := <-taskQueue:
+			fmt.Println("TaskQueue of WorkerID = ", workerID, " is full, drop oldest msgID = ", oldest.GetMsgID())
+			atomic.AddUint64(&counter.dropped, 1)
+		default:
+		}
+		select {
+		case taskQueue <- request:
+			atomic.AddUint64(&counter.dispatched, 1)
+		default:
+			atomic.AddUint64(&counter.dropped, 1)
+		}
+	case utils.TaskQueueOverflowReject:
+		atomic.AddUint64(&counter.rejected, 1)
+		mh.rejectRequest(request)
+	default:
+		//timeout为nil时一直等待
+		var timeout <-chan time.Time
+		if utils.GlobalObject.TaskQueueBlockTimeout > 0 {
+			timer := time.NewTimer(time.Duration(utils.GlobalObject.TaskQueueBlockTimeout) * time.Millisecond)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case taskQueue <- request:
+			atomic.AddUint64(&counter.dispatched, 1)
+		case <-timeout:
+			fmt.Println("TaskQueue of WorkerID = ", workerID, " wait timeout, drop msgID = ", request.GetMsgID())
+			atomic.AddUint64(&counter.dropped, 1)
+		}
+	}
+}
+
+//拒绝一个请求,配置了TaskQueueFullMsgID时回复客户端一个TaskQueueFullMsg
+func (mh *MsgHandle) rejectRequest(request ziface.IRequest) {
+	if utils.GlobalObject.TaskQueueFullMsgID == 0 {
+		return
+	}
+
+	data, err := json.Marshal(&TaskQueueFullMsg{
+		Code:  TaskQueueFullCode,
+		MsgID: request.GetMsgID(),
+		Msg:   "server busy",
+	})
+	if err != nil {
+		fmt.Println("marshal task queue full msg err: ", err)
+		return
+	}
+	//不论MsgChanOverflow是什么策略都不等待发送队列,发送队列满时不回复,避免阻塞Reader
+	if err := trySendBuffMsg(request.GetConnection(), utils.GlobalObject.TaskQueueFullMsgID, data); err != nil {
+		fmt.Println("send task queue full msg err: ", err)
+	}
+}
+
+//获取每个Worker的统计数据
+func (mh *MsgHandle) GetWorkerStats() []ziface.WorkerStats {
+	stats := make([]ziface.WorkerStats, len(mh.workerCounters))
+	for i := range mh.workerCounters {
+		counter := &mh.workerCounters[i]
+		stats[i] = ziface.WorkerStats{
+			WorkerID:   uint32(i),
+			QueueLen:   mh.laneLen(uint32(i)),
+			Dispatched: atomic.LoadUint64(&counter.dispatched),
+			Processed:  atomic.LoadUint64(&counter.processed),
+			Dropped:    atomic.LoadUint64(&counter.dropped),
+			Rejected:   atomic.LoadUint64(&counter.rejected),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,127 @@
+package znet
+
+import (
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+func TestTaskQueueOverflow(t *testing.T) {
+	defer func(policy string, timeout int, msgID uint32) {
+		utils.GlobalObject.TaskQueueOverflow = policy
+		utils.GlobalObject.TaskQueueBlockTimeout = timeout
+		utils.GlobalObject.TaskQueueFullMsgID = msgID
+	}(utils.GlobalObject.TaskQueueOverflow, utils.GlobalObject.TaskQueueBlockTimeout, utils.GlobalObject.TaskQueueFullMsgID)
+	utils.GlobalObject.TaskQueueBlockTimeout = 10
+	utils.GlobalObject.TaskQueueFullMsgID = 503
+
+	s := NewServer("[zinx test]").(*Server)
+	conn := NewConnection(s, nil, 0, s.MsgHandler)
+	newRequest := func(msgID uint32) ziface.IRequest {
+		return &Request{conn: conn, msg: NewMsgPackage(msgID, nil)}
+	}
+
+	tests := []struct {
+		policy   string
+		wantHead uint32 //队列中剩下的请求
+		dropped  uint64
+		rejected uint64
+	}{
+		{utils.TaskQueueOverflowDropNewest, 1, 1, 0},
+		{utils.TaskQueueOverflowDropOldest, 2, 1, 0},
+		{utils.TaskQueueOverflowReject, 1, 0, 1},
+		{utils.TaskQueueOverflowBlock, 1, 1, 0},
+	}
+	for _, tt := range tests {
+		utils.GlobalObject.TaskQueueOverflow = tt.policy
+
+		//没有启动Worker,队列只能放一个请求
+		mh := NewMsgHandle()
+		mh.TaskQueue[0] = make(chan ziface.IRequest, 1)
+		mh.enqueue(0, newRequest(1))
+		mh.enqueue(0, newRequest(2))
+
+		if head := (<-mh.TaskQueue[0]).GetMsgID(); head != tt.wantHead {
+			t.Errorf("%s: queued msgID = %d, want %d", tt.policy, head, tt.wantHead)
+		}
+		stats := mh.GetWorkerStats()[0]
+		if stats.Dropped != tt.dropped || stats.Rejected != tt.rejected {
+			t.Errorf("%s: stats = %+v", tt.policy, stats)
+		}
+	}
+
+	//拒绝的请求回复客户端TaskQueueFullMsg
+	if len(conn.msgBuffChan) != 1 {
+		t.Fatalf("reject reply count = %d, want 1", len(conn.msgBuffChan))
+	}
+	msg, err := NewDataPack().Unpack(<-conn.msgBuffChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgId() != 503 {
+		t.Errorf("reject reply msgID = %d, want 503", msg.GetMsgId())
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	s := NewServer("[zinx test]").(*Server)
+	s.AddHandlerFunc(1, func(request ziface.IRequest) {})
+	mh := s.MsgHandler.(*MsgHandle)
+	mh.StartWorkerPool()
+
+	conn := NewConnection(s, nil, 3, mh)
+	for i := 0; i < 5; i++ {
+		mh.SendMsgToTaskQueue(&Request{conn: conn, msg: NewMsgPackage(1, nil)})
+	}
+	mh.StopWorkerPool()
+
+	stats := mh.GetWorkerStats()
+	if len(stats) != int(mh.WorkerPoolSize) {
+		t.Fatalf("stats len = %d, want %d", len(stats), mh.WorkerPoolSize)
+	}
+	got := stats[3]
+	if got.Dispatched != 5 || got.Processed != 5 || got.QueueLen != 0 {
+		t.Errorf("worker 3 stats = %+v", got)
+	}
+}
+
+//MsgChanOverflowBlock并且不超时的配置下,发送队列满的连接也不能阻塞拒绝请求的Reader
+func TestTaskQueueRejectFullMsgChan(t *testing.T) {
+	defer func(policy string, msgID uint32, chanPolicy string, timeout int, chanLen uint32) {
+		utils.GlobalObject.TaskQueueOverflow = policy
+		utils.GlobalObject.TaskQueueFullMsgID = msgID
+		utils.GlobalObject.MsgChanOverflow = chanPolicy
+		utils.GlobalObject.MsgChanBlockTimeout = timeout
+		utils.GlobalObject.MaxMsgChanLen = chanLen
+	}(utils.GlobalObject.TaskQueueOverflow, utils.GlobalObject.TaskQueueFullMsgID, utils.GlobalObject.MsgChanOverflow,
+		utils.GlobalObject.MsgChanBlockTimeout, utils.GlobalObject.MaxMsgChanLen)
+	utils.GlobalObject.TaskQueueOverflow = utils.TaskQueueOverflowReject
+	utils.GlobalObject.TaskQueueFullMsgID = 503
+	utils.GlobalObject.MsgChanOverflow = utils.MsgChanOverflowBlock
+	utils.GlobalObject.MsgChanBlockTimeout = 0
+	utils.GlobalObject.MaxMsgChanLen = 1
+
+	s := NewServer("[zinx test]").(*Server)
+	//没有启动Writer的连接,发送队列已经满了
+	conn := NewConnection(s, nil, 0, s.MsgHandler)
+	conn.msgBuffChan <- []byte("pending")
+
+	mh := NewMsgHandle()
+	mh.TaskQueue[0] = make(chan ziface.IRequest, 1)
+	mh.enqueue(0, &Request{conn: conn, msg: NewMsgPackage(1, nil)})
+
+	done := make(chan struct{})
+	go func() {
+		mh.enqueue(0, &Request{conn: conn, msg: NewMsgPackage(2, nil)})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("rejectRequest blocked by a full msg buff chan")
+	}
+	if stats := mh.GetWorkerStats()[0]; stats.Rejected != 1 {
+		t.Errorf("rejected = %d, want 1", stats.Rejected)
+	}
+}
@@ -0,0 +1,50 @@
+package znet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"zinx/utils"
+)
+
+//根据zinx.json中配置的证书路径加载TLS配置,已经通过WithTLSConfig设置时不做处理
+func (s *Server) loadTLSConfig() error {
+	if s.tlsConfig != nil || utils.GlobalObject.CertFile == "" || utils.GlobalObject.KeyFile == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(utils.GlobalObject.CertFile, utils.GlobalObject.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	//配置了客户端的CA,要求客户端提供证书并进行校验
+	if utils.GlobalObject.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(utils.GlobalObject.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return errors.New("no valid certificate in ClientCAFile")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	s.tlsConfig = config
+	return nil
+}
+
+//开启了TLS时,将TCP连接包装为TLS连接
+func (s *Server) wrapTLS(conn net.Conn) net.Conn {
+	if s.tlsConfig == nil {
+		return conn
+	}
+	return tls.Server(conn, s.tlsConfig)
+}
@@ -0,0 +1,145 @@
+package znet
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//生成一个自签名的证书
+func newTestCert(t *testing.T, cn string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+//回复客户端证书的CommonName
+type peerNameRouter struct {
+	BaseRouter
+}
+
+func (r *peerNameRouter) Handle(request ziface.IRequest) {
+	state := request.GetConnection().GetTLSConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		request.GetConnection().SendMsg(request.GetMsgID(), nil)
+		return
+	}
+	request.GetConnection().SendMsg(request.GetMsgID(), []byte(state.PeerCertificates[0].Subject.CommonName))
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	serverCert, serverX509 := newTestCert(t, "zinx server")
+	clientCert, clientX509 := newTestCert(t, "player-1001")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(serverX509)
+
+	utils.GlobalObject.TcpPort = 7786
+	utils.GlobalObject.Host = "127.0.0.1"
+	s := NewServer("[zinx test]", WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}))
+	s.AddRouter(1, &peerNameRouter{})
+	s.Start()
+	defer s.Stop()
+
+	tcpConn := dialTestServer(t, 7786)
+	conn := tls.Client(tcpConn, &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      rootCAs,
+		ServerName:   "127.0.0.1",
+	})
+	defer conn.Close()
+
+	dp := NewDataPack()
+	data, _ := dp.Pack(NewMsgPackage(1, nil))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := readMessage(bufio.NewReader(conn), dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "player-1001" {
+		t.Errorf("peer name = %q, want %q", msg.GetData(), "player-1001")
+	}
+}
+
+//TLS握手失败的连接没有调用过OnConnStart,也不调用OnConnStop
+func TestServerTLSHandshakeFailed(t *testing.T) {
+	serverCert, _ := newTestCert(t, "zinx server")
+	utils.GlobalObject.TcpPort = 7804
+	utils.GlobalObject.Host = "127.0.0.1"
+	s := NewServer("[zinx test]", WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+	}))
+	var startCalls, stopCalls int32
+	s.SetOnConnStart(func(conn ziface.IConnection) {
+		atomic.AddInt32(&startCalls, 1)
+	})
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		atomic.AddInt32(&stopCalls, 1)
+	})
+	s.Start()
+	defer s.Stop()
+
+	//不是TLS的客户端
+	conn := dialTestServer(t, 7804)
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("connection not closed by server: %v", err)
+	}
+
+	if !waitFor(func() bool { return s.GetConnMgr().Len() == 0 }) {
+		t.Errorf("ConnMgr still has %d connections", s.GetConnMgr().Len())
+	}
+	if n := atomic.LoadInt32(&startCalls); n != 0 {
+		t.Errorf("OnConnStart called %d times, want 0", n)
+	}
+	if n := atomic.LoadInt32(&stopCalls); n != 0 {
+		t.Errorf("OnConnStop called %d times, want 0", n)
+	}
+}
@@ -0,0 +1,936 @@
+package znet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//UDP传输的数据报格式:
+//|flags(1字节)|sessionID(4字节)|seq(4字节)|ack(4字节)|一个完整的封包消息
+//每个数据报承载一个由IDataPack封包的完整消息,把数据报的内容依次拼接起来就是和TCP一样的数据流
+//
+//建立会话之前需要一次cookie握手,证明客户端确实拥有数据报的源地址:
+//
+//	客户端 -> Hello(sessionID为0,数据填充到cookie的长度)
+//	服务端 -> Challenge(cookie,由客户端地址和时间计算,服务端不保存状态)
+//	客户端 -> Hello(原样带回cookie)
+//	服务端 -> Welcome(分配的sessionID),此时才创建会话交给Server
+//
+//会话的地址在握手时确定,之后不会改变:会话ID在每个数据报中明文传输,不能用来证明对会话的所有权,
+//所以来自其他地址的数据报即使带有已有会话的ID也会被丢弃
+//服务端回复的数据报都不会比收到的数据报大,避免被用来放大反射流量
+const (
+	udpHeadLen = 13
+
+	udpFlagReliable  = 1 << 0 //可靠消息,需要对端回复ack,丢失时重传,并按照seq的顺序交付
+	udpFlagAck       = 1 << 1 //确认收到了对端seq为ack的可靠消息
+	udpFlagClose     = 1 << 2 //通知对端会话已经关闭
+	udpFlagHello     = 1 << 3 //客户端请求建立会话
+	udpFlagChallenge = 1 << 4 //服务端要求客户端带回cookie
+	udpFlagWelcome   = 1 << 5 //服务端确认会话已经建立,sessionID为分配的会话ID
+
+	//cookie的长度: 生成的时间(8字节) + HMAC-SHA256的前16字节
+	udpCookieLen = 24
+	//cookie的有效期
+	udpCookieTimeout = 10 * time.Second
+	//客户端握手的最长时间
+	udpHandshakeTimeout = 5 * time.Second
+
+	//一个UDP数据报的最大长度
+	udpMaxDatagram = 65507
+	//可靠消息的重传间隔
+	udpRetransmitInterval = 200 * time.Millisecond
+	//可靠消息的最大重传次数,超过之后关闭会话
+	udpMaxRetransmit = 20
+	//等待ack的可靠消息的最大数量,超过之后Write会阻塞
+	udpSendWindow = 256
+	//已经收到还没有被Reader读取的消息的最大数量
+	udpRecvQueueLen = 256
+	//乱序到达的可靠消息最多缓存的数量
+	udpRecvWindow = 256
+	//新会话的Accept队列长度
+	udpAcceptQueueLen = 128
+)
+
+//UDP会话的超时错误,实现了net.Error,连接关闭的原因会被归类为超时
+type udpTimeoutError struct {
+	msg string
+}
+
+func (e *udpTimeoutError) Error() string   { return e.msg }
+func (e *udpTimeoutError) Timeout() bool   { return true }
+func (e *udpTimeoutError) Temporary() bool { return true }
+
+var (
+	//UDP会话超过UdpIdleTimeout没有收到任何数据
+	ErrUdpSessionExpired error = &udpTimeoutError{"udp session expired"}
+	//可靠消息超过最大重传次数仍然没有收到ack
+	ErrUdpRetransmit error = &udpTimeoutError{"udp reliable msg not acked"}
+	//消息太大,无法放入一个UDP数据报
+	ErrUdpMsgTooLarge = errors.New("udp msg too large for one datagram")
+	//DialUDP没有在udpHandshakeTimeout内完成握手
+	ErrUdpHandshakeTimeout error = &udpTimeoutError{"udp handshake timeout"}
+
+	//读取UDP会话超时
+	errUdpReadTimeout = &udpTimeoutError{"udp session read timeout"}
+)
+
+//已经发送还没有收到ack的可靠消息
+type udpPending struct {
+	datagram []byte    //完整的数据报,重传时直接发送
+	sentAt   time.Time //最后一次发送的时间
+	retries  int       //已经重传的次数
+}
+
+//UDP会话,将一个远程地址上的数据报包装为net.Conn,交给Connection使用
+type udpSession struct {
+	//会话的ID,客户端在握手完成时得到,只能通过atomic读写
+	id uint32
+	//发送数据报使用的UDP套接字,服务端所有的会话共用一个
+	conn *net.UDPConn
+	//对端的地址
+	remote *net.UDPAddr
+	//客户端的会话,conn是已经connect到服务端的套接字
+	isClient bool
+	//判断一个消息是否需要可靠传输,为nil时全部不可靠传输
+	reliable func(data []byte) bool
+	//会话关闭时的回调,服务端用来把会话从监听器中移除
+	onClose func(s *udpSession)
+
+	//交付给Reader的消息
+	recvChan chan []byte
+	//当前正在被Reader读取的消息剩余的数据,只在Read中使用
+	readBuf []byte
+	//读取的截止时间
+	readDeadline time.Time
+	//修改截止时间时close,唤醒正在等待的Read
+	deadlineChanged chan struct{}
+	deadlineLock    sync.Mutex
+	//最后一次收到对端数据报的时间(UnixNano)
+	lastRecv int64
+
+	//接收的状态
+	recvLock sync.Mutex
+	//下一个应该交付的可靠消息的seq
+	nextRecvSeq uint32
+	//乱序到达,等待交付的可靠消息
+	pending map[uint32][]byte
+	//最后一个交付的不可靠消息的seq,更旧的不可靠消息直接丢弃
+	lastUnreliableSeq uint32
+
+	//发送的状态
+	sendLock sync.Mutex
+	//下一个可靠消息的seq
+	nextSendSeq uint32
+	//下一个不可靠消息的seq,只能通过atomic读写
+	nextUnreliableSeq uint32
+	//等待ack的可靠消息
+	unacked map[uint32]*udpPending
+	//可靠消息的发送窗口
+	window chan struct{}
+
+	//会话关闭时close的channel
+	closeChan chan struct{}
+	closeOnce sync.Once
+	//导致会话关闭的错误,closeChan关闭之后才可以读取
+	closeErr error
+}
+
+//创建一个UDP会话
+func newUdpSession(id uint32, conn *net.UDPConn, remote *net.UDPAddr, isClient bool, reliable func(data []byte) bool) *udpSession {
+	s := &udpSession{
+		id:          id,
+		conn:        conn,
+		isClient:    isClient,
+		reliable:    reliable,
+		recvChan:    make(chan []byte, udpRecvQueueLen),
+		lastRecv:    time.Now().UnixNano(),
+		nextRecvSeq: 1,
+		pending:     make(map[uint32][]byte),
+		nextSendSeq: 1,
+		unacked:     make(map[uint32]*udpPending),
+		window:      make(chan struct{}, udpSendWindow),
+		closeChan:   make(chan struct{}),
+
+		deadlineChanged: make(chan struct{}),
+		remote:          remote,
+	}
+
+	//开启可靠消息的重传
+	go s.retransmitLoop()
+	return s
+}
+
+//读取对端发送的消息
+func (s *udpSession) Read(p []byte) (int, error) {
+	if len(s.readBuf) == 0 {
+		data, err := s.nextMsg()
+		if err != nil {
+			return 0, err
+		}
+		s.readBuf = data
+		//Reader取走了一个消息,尝试交付缓存中的可靠消息
+		s.flushPending()
+	}
+
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+//等待下一个交付的消息
+func (s *udpSession) nextMsg() ([]byte, error) {
+	for {
+		s.deadlineLock.Lock()
+		deadline, changed := s.readDeadline, s.deadlineChanged
+		s.deadlineLock.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return nil, errUdpReadTimeout
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case data := <-s.recvChan:
+			stopTimer(timer)
+			return data, nil
+		case <-s.closeChan:
+			stopTimer(timer)
+			//会话关闭之前已经收到的消息仍然交给Reader
+			select {
+			case data := <-s.recvChan:
+				return data, nil
+			default:
+				return nil, s.closeErr
+			}
+		case <-timeout:
+			return nil, errUdpReadTimeout
+		case <-changed:
+			//截止时间被修改,按照新的截止时间重新等待
+			stopTimer(timer)
+		}
+	}
+}
+
+//停止一个可能为nil的定时器
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+//每次Write发送一个数据报,需要可靠传输的消息在收到ack之前会不断重传
+func (s *udpSession) Write(p []byte) (int, error) {
+	if len(p)+udpHeadLen > udpMaxDatagram {
+		return 0, ErrUdpMsgTooLarge
+	}
+	select {
+	case <-s.closeChan:
+		return 0, s.closeErr
+	default:
+	}
+
+	if s.reliable == nil || !s.reliable(p) {
+		seq := atomic.AddUint32(&s.nextUnreliableSeq, 1)
+		s.send(s.newDatagram(0, seq, 0, p))
+		return len(p), nil
+	}
+
+	//等待发送窗口有空位
+	select {
+	case s.window <- struct{}{}:
+	case <-s.closeChan:
+		return 0, s.closeErr
+	}
+
+	s.sendLock.Lock()
+	seq := s.nextSendSeq
+	s.nextSendSeq++
+	datagram := s.newDatagram(udpFlagReliable, seq, 0, p)
+	s.unacked[seq] = &udpPending{datagram: datagram, sentAt: time.Now()}
+	s.sendLock.Unlock()
+
+	s.send(datagram)
+	return len(p), nil
+}
+
+//处理收到的一个数据报,只会在读取UDP套接字的Goroutine中调用
+func (s *udpSession) input(flags byte, seq, ack uint32, payload []byte) {
+	atomic.StoreInt64(&s.lastRecv, time.Now().UnixNano())
+
+	switch {
+	case flags&udpFlagClose != 0:
+		s.closeWithErr(io.EOF, false)
+	case flags&udpFlagAck != 0:
+		s.handleAck(ack)
+	case flags&udpFlagReliable != 0:
+		s.handleReliable(seq, payload)
+	default:
+		s.handleUnreliable(seq, payload)
+	}
+}
+
+//对端确认收到了可靠消息,释放发送窗口
+func (s *udpSession) handleAck(seq uint32) {
+	s.sendLock.Lock()
+	_, ok := s.unacked[seq]
+	delete(s.unacked, seq)
+	s.sendLock.Unlock()
+
+	if ok {
+		<-s.window
+	}
+}
+
+//按照seq的顺序交付可靠消息,只有放入了接收队列或者乱序缓存的消息才回复ack
+func (s *udpSession) handleReliable(seq uint32, payload []byte) {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+
+	//已经交付过的消息,对端没有收到ack,再次回复
+	if int32(seq-s.nextRecvSeq) < 0 {
+		s.sendAck(seq)
+		return
+	}
+	if _, ok := s.pending[seq]; ok {
+		s.sendAck(seq)
+		return
+	}
+
+	if seq == s.nextRecvSeq {
+		if !s.deliver(payload) {
+			//接收队列已满,不回复ack,等待对端重传
+			return
+		}
+		s.sendAck(seq)
+		s.nextRecvSeq++
+		s.flushPendingLocked()
+		return
+	}
+
+	//乱序到达的消息先缓存起来
+	if seq-s.nextRecvSeq < udpRecvWindow && len(s.pending) < udpRecvWindow {
+		s.pending[seq] = append([]byte(nil), payload...)
+		s.sendAck(seq)
+	}
+}
+
+//不可靠的消息只保证不会交付比已交付的消息更旧的消息
+func (s *udpSession) handleUnreliable(seq uint32, payload []byte) {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+
+	if int32(seq-s.lastUnreliableSeq) <= 0 {
+		return
+	}
+	if s.deliver(payload) {
+		s.lastUnreliableSeq = seq
+	}
+}
+
+//将消息放入接收队列,队列已满时返回false
+func (s *udpSession) deliver(payload []byte) bool {
+	select {
+	case s.recvChan <- append([]byte(nil), payload...):
+		return true
+	default:
+		return false
+	}
+}
+
+//交付乱序缓存中已经可以按顺序交付的消息
+func (s *udpSession) flushPending() {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+	s.flushPendingLocked()
+}
+
+func (s *udpSession) flushPendingLocked() {
+	for {
+		data, ok := s.pending[s.nextRecvSeq]
+		if !ok || !s.deliver(data) {
+			return
+		}
+		delete(s.pending, s.nextRecvSeq)
+		s.nextRecvSeq++
+	}
+}
+
+//定时重传没有收到ack的可靠消息
+func (s *udpSession) retransmitLoop() {
+	ticker := time.NewTicker(udpRetransmitInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !s.retransmit(time.Now()) {
+				s.closeWithErr(ErrUdpRetransmit, true)
+				return
+			}
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+//重传超时的可靠消息,有消息超过最大重传次数时返回false
+func (s *udpSession) retransmit(now time.Time) bool {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	for _, p := range s.unacked {
+		if now.Sub(p.sentAt) < udpRetransmitInterval {
+			continue
+		}
+		if p.retries >= udpMaxRetransmit {
+			return false
+		}
+		p.retries++
+		p.sentAt = now
+		s.send(p.datagram)
+	}
+	return true
+}
+
+//回复对端收到了seq的可靠消息
+func (s *udpSession) sendAck(seq uint32) {
+	s.send(s.newDatagram(udpFlagAck, 0, seq, nil))
+}
+
+//组装一个数据报
+func (s *udpSession) newDatagram(flags byte, seq, ack uint32, payload []byte) []byte {
+	datagram := make([]byte, udpHeadLen+len(payload))
+	datagram[0] = flags
+	binary.LittleEndian.PutUint32(datagram[1:], atomic.LoadUint32(&s.id))
+	binary.LittleEndian.PutUint32(datagram[5:], seq)
+	binary.LittleEndian.PutUint32(datagram[9:], ack)
+	copy(datagram[udpHeadLen:], payload)
+	return datagram
+}
+
+//发送一个数据报,UDP发送失败时不做处理,可靠消息会重传
+func (s *udpSession) send(datagram []byte) {
+	var err error
+	if s.isClient {
+		_, err = s.conn.Write(datagram)
+	} else {
+		_, err = s.conn.WriteToUDP(datagram, s.remote)
+	}
+	if err != nil {
+		fmt.Println("udp send to ", s.RemoteAddr(), " err: ", err)
+	}
+}
+
+//以err关闭会话,notify为true时通知对端会话已经关闭
+func (s *udpSession) closeWithErr(err error, notify bool) {
+	s.closeOnce.Do(func() {
+		if notify {
+			s.send(s.newDatagram(udpFlagClose, 0, 0, nil))
+		}
+		s.closeErr = err
+		close(s.closeChan)
+
+		if s.onClose != nil {
+			s.onClose(s)
+		}
+		//客户端的套接字只属于当前会话
+		if s.isClient {
+			s.conn.Close()
+		}
+	})
+}
+
+//关闭会话,并通知对端
+func (s *udpSession) Close() error {
+	s.closeWithErr(io.EOF, true)
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	s.deadlineLock.Lock()
+	defer s.deadlineLock.Unlock()
+	s.readDeadline = t
+	close(s.deadlineChanged)
+	s.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+//UDP的发送不会阻塞,不需要写截止时间
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+//解析数据报的头部
+func parseUdpHead(datagram []byte) (flags byte, id, seq, ack uint32, ok bool) {
+	if len(datagram) < udpHeadLen {
+		return 0, 0, 0, 0, false
+	}
+	flags = datagram[0]
+	id = binary.LittleEndian.Uint32(datagram[1:])
+	seq = binary.LittleEndian.Uint32(datagram[5:])
+	ack = binary.LittleEndian.Uint32(datagram[9:])
+	return flags, id, seq, ack, true
+}
+
+//根据UdpReliableMsgIDs得到判断消息是否需要可靠传输的方法
+func udpReliableFunc(packet ziface.IDataPack, msgIDs []uint32) func(data []byte) bool {
+	if len(msgIDs) == 0 {
+		return nil
+	}
+	reliableIDs := make(map[uint32]bool, len(msgIDs))
+	for _, id := range msgIDs {
+		reliableIDs[id] = true
+	}
+
+	return func(data []byte) bool {
+		//包头长度不固定的封包格式,Unpack从data的开头解析包头
+		head := data
+		if headLen := packet.GetHeadLen(); headLen > 0 {
+			if uint32(len(data)) < headLen {
+				return false
+			}
+			head = data[:headLen]
+		}
+		msg, err := packet.Unpack(head)
+		return err == nil && reliableIDs[msg.GetMsgId()]
+	}
+}
+
+//UDP的监听器,将每个远程客户端的数据报映射为一个udpSession
+type udpListener struct {
+	conn *net.UDPConn
+	//判断一个消息是否需要可靠传输
+	reliable func(data []byte) bool
+	//会话空闲多久之后过期,0表示不过期
+	idleTimeout time.Duration
+	//会话的最大数量,0表示不限制
+	maxSessions int
+	//计算cookie的密钥,每个监听器随机生成
+	cookieSecret []byte
+
+	//当前所有的会话
+	sessions map[uint32]*udpSession
+	//根据客户端地址找到会话
+	byAddr      map[string]*udpSession
+	sessionLock sync.Mutex
+
+	//新建立的会话
+	acceptChan chan *udpSession
+	//停止接受新的会话时close,已有的会话不受影响
+	acceptClosed    chan struct{}
+	acceptCloseOnce sync.Once
+	closeChan       chan struct{}
+	closeOnce       sync.Once
+}
+
+//监听UDP地址
+func listenUDP(address string, reliable func(data []byte) bool, idleTimeout time.Duration, maxSessions int) (*udpListener, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cookieSecret := make([]byte, 32)
+	if _, err := rand.Read(cookieSecret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	l := &udpListener{
+		conn:         conn,
+		reliable:     reliable,
+		idleTimeout:  idleTimeout,
+		maxSessions:  maxSessions,
+		cookieSecret: cookieSecret,
+		sessions:     make(map[uint32]*udpSession),
+		byAddr:       make(map[string]*udpSession),
+		acceptChan:   make(chan *udpSession, udpAcceptQueueLen),
+		acceptClosed: make(chan struct{}),
+		closeChan:    make(chan struct{}),
+	}
+	go l.readLoop()
+	if idleTimeout > 0 {
+		go l.expireLoop()
+	}
+	return l, nil
+}
+
+//等待一个新的会话
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.acceptChan:
+		return s, nil
+	case <-l.acceptClosed:
+		return nil, net.ErrClosed
+	case <-l.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+//停止接受新的会话,Accept返回net.ErrClosed,已经建立的会话继续工作直到Close
+func (l *udpListener) stopAccept() {
+	l.acceptCloseOnce.Do(func() {
+		close(l.acceptClosed)
+	})
+}
+
+//关闭监听器以及所有的会话
+func (l *udpListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+
+		l.sessionLock.Lock()
+		sessions := make([]*udpSession, 0, len(l.sessions))
+		for _, s := range l.sessions {
+			sessions = append(sessions, s)
+		}
+		l.sessionLock.Unlock()
+		for _, s := range sessions {
+			s.Close()
+		}
+
+		err = l.conn.Close()
+	})
+	return err
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+//读取所有客户端的数据报,分发给对应的会话
+func (l *udpListener) readLoop() {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.closeChan:
+				return
+			default:
+			}
+			fmt.Println("udp read err: ", err)
+			continue
+		}
+
+		flags, id, seq, ack, ok := parseUdpHead(buf[:n])
+		if !ok {
+			continue
+		}
+		if flags&udpFlagHello != 0 {
+			l.handleHello(id, addr, buf[udpHeadLen:n])
+			continue
+		}
+		s := l.session(id, addr, flags)
+		if s != nil {
+			s.input(flags, seq, ack, buf[udpHeadLen:n])
+		}
+	}
+}
+
+//找到数据报对应的会话,只有完成握手的会话才会收到数据
+func (l *udpListener) session(id uint32, addr *net.UDPAddr, flags byte) *udpSession {
+	//没有完成握手的客户端
+	if id == 0 {
+		return nil
+	}
+
+	l.sessionLock.Lock()
+	s, ok := l.sessions[id]
+	l.sessionLock.Unlock()
+
+	if !ok {
+		//会话已经不存在了,通知客户端
+		if flags&udpFlagClose == 0 {
+			l.sendTo(udpFlagClose, id, nil, addr)
+		}
+		return nil
+	}
+	//会话的地址不会改变,其他地址带着这个会话ID发来的数据报直接丢弃
+	if s.remote.String() != addr.String() {
+		return nil
+	}
+	return s
+}
+
+//处理客户端的Hello,cookie校验通过之后建立会话
+func (l *udpListener) handleHello(id uint32, addr *net.UDPAddr, payload []byte) {
+	//Hello需要填充到cookie的长度,保证回复的Challenge不会比Hello大
+	if len(payload) < udpCookieLen {
+		return
+	}
+	//不支持已有会话迁移到新的地址
+	if id != 0 {
+		return
+	}
+	if !l.verifyCookie(payload[:udpCookieLen], addr) {
+		l.sendTo(udpFlagChallenge, 0, l.newCookie(addr, time.Now()), addr)
+		return
+	}
+
+	l.sessionLock.Lock()
+	defer l.sessionLock.Unlock()
+
+	//Welcome丢失时客户端会重新发送Hello
+	if s, ok := l.byAddr[addr.String()]; ok {
+		l.sendTo(udpFlagWelcome, s.id, nil, addr)
+		return
+	}
+	//已经停止接受新的会话
+	select {
+	case <-l.acceptClosed:
+		return
+	default:
+	}
+	if l.maxSessions > 0 && len(l.sessions) >= l.maxSessions {
+		fmt.Println("Too Many UDP Sessions UdpMaxSessions = ", l.maxSessions, ", drop hello from ", addr)
+		return
+	}
+
+	//创建一个新的会话
+	s := newUdpSession(l.newSessionID(), l.conn, addr, false, l.reliable)
+	s.onClose = l.remove
+	select {
+	case l.acceptChan <- s:
+	default:
+		//Accept来不及处理,丢弃这个会话,客户端会重新发送Hello
+		fmt.Println("udp accept queue is full, drop session from ", addr)
+		s.onClose = nil
+		s.closeWithErr(io.EOF, false)
+		return
+	}
+	l.sessions[s.id] = s
+	l.byAddr[addr.String()] = s
+	l.sendTo(udpFlagWelcome, s.id, nil, addr)
+}
+
+//不经过会话直接发送一个数据报
+func (l *udpListener) sendTo(flags byte, id uint32, payload []byte, addr *net.UDPAddr) {
+	datagram := make([]byte, udpHeadLen+len(payload))
+	datagram[0] = flags
+	binary.LittleEndian.PutUint32(datagram[1:], id)
+	copy(datagram[udpHeadLen:], payload)
+	l.conn.WriteToUDP(datagram, addr)
+}
+
+//根据客户端的地址和时间计算cookie
+func (l *udpListener) newCookie(addr *net.UDPAddr, now time.Time) []byte {
+	cookie := make([]byte, udpCookieLen)
+	binary.LittleEndian.PutUint64(cookie, uint64(now.Unix()))
+
+	mac := hmac.New(sha256.New, l.cookieSecret)
+	mac.Write(cookie[:8])
+	mac.Write(addr.IP.To16())
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(addr.Port))
+	mac.Write(buf[:])
+	copy(cookie[8:], mac.Sum(nil))
+	return cookie
+}
+
+//校验客户端带回的cookie是不是为当前地址生成的,并且没有过期
+func (l *udpListener) verifyCookie(cookie []byte, addr *net.UDPAddr) bool {
+	created := time.Unix(int64(binary.LittleEndian.Uint64(cookie)), 0)
+	now := time.Now()
+	if now.Sub(created) > udpCookieTimeout || created.After(now) {
+		return false
+	}
+	return hmac.Equal(cookie, l.newCookie(addr, created))
+}
+
+//生成一个随机的会话ID,避免会话ID被猜到
+func (l *udpListener) newSessionID() uint32 {
+	b := make([]byte, 4)
+	for {
+		rand.Read(b)
+		id := binary.LittleEndian.Uint32(b)
+		if _, ok := l.sessions[id]; id != 0 && !ok {
+			return id
+		}
+	}
+}
+
+//会话关闭时从监听器中移除
+func (l *udpListener) remove(s *udpSession) {
+	l.sessionLock.Lock()
+	defer l.sessionLock.Unlock()
+
+	if l.sessions[s.id] == s {
+		delete(l.sessions, s.id)
+	}
+	addr := s.remote.String()
+	if l.byAddr[addr] == s {
+		delete(l.byAddr, addr)
+	}
+}
+
+//定时关闭空闲超时的会话
+func (l *udpListener) expireLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			l.sessionLock.Lock()
+			var expired []*udpSession
+			for _, s := range l.sessions {
+				if now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastRecv))) > l.idleTimeout {
+					expired = append(expired, s)
+				}
+			}
+			l.sessionLock.Unlock()
+
+			for _, s := range expired {
+				s.closeWithErr(ErrUdpSessionExpired, true)
+			}
+		case <-l.closeChan:
+			return
+		}
+	}
+}
+
+//连接UDP服务端,完成握手之后返回的net.Conn可以像TCP连接一样读写封包的消息
+//packet需要和服务端使用的封包格式一致,UdpReliableMsgIDs中的消息使用可靠传输
+func DialUDP(address string, packet ziface.IDataPack) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newUdpSession(0, conn, raddr, true, udpReliableFunc(packet, utils.GlobalObject.UdpReliableMsgIDs))
+	welcome := make(chan struct{})
+	go func() {
+		buf := make([]byte, udpMaxDatagram)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					s.closeWithErr(io.EOF, false)
+					return
+				}
+				//服务端还没有开始监听时会收到ICMP端口不可达,可靠消息会继续重传
+				continue
+			}
+			flags, id, seq, ack, ok := parseUdpHead(buf[:n])
+			if !ok {
+				continue
+			}
+			switch {
+			case flags&udpFlagChallenge != 0:
+				//带回服务端的cookie
+				s.sendHello(buf[udpHeadLen:n])
+			case flags&udpFlagWelcome != 0:
+				//记录服务端分配的会话ID
+				if atomic.CompareAndSwapUint32(&s.id, 0, id) {
+					close(welcome)
+				}
+			case id == atomic.LoadUint32(&s.id):
+				s.input(flags, seq, ack, buf[udpHeadLen:n])
+			}
+		}
+	}()
+
+	//握手完成之前定时重发Hello,服务端还没有开始监听时也会继续尝试
+	timeout := time.NewTimer(udpHandshakeTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(udpRetransmitInterval)
+	defer ticker.Stop()
+	for {
+		s.sendHello(nil)
+		select {
+		case <-welcome:
+			return s, nil
+		case <-ticker.C:
+		case <-timeout.C:
+			s.closeWithErr(ErrUdpHandshakeTimeout, false)
+			return nil, ErrUdpHandshakeTimeout
+		}
+	}
+}
+
+//客户端发送Hello,cookie为空时填充到cookie的长度
+func (s *udpSession) sendHello(cookie []byte) {
+	payload := make([]byte, udpCookieLen)
+	copy(payload, cookie)
+	s.send(s.newDatagram(udpFlagHello, 0, 0, payload))
+}
+
+//开启UDP服务,每个客户端的会话通过handleConn成为一个连接,和TCP共用路由和连接管理
+func (s *Server) startUDP() error {
+	reliable := udpReliableFunc(s.packet, utils.GlobalObject.UdpReliableMsgIDs)
+	idleTimeout := time.Duration(utils.GlobalObject.UdpIdleTimeout) * time.Second
+	listener, err := listenUDP(net.JoinHostPort(s.IP, strconv.Itoa(utils.GlobalObject.UdpPort)), reliable, idleTimeout, utils.GlobalObject.UdpMaxSessions)
+	if err != nil {
+		return err
+	}
+
+	//如果在监听的过程中Server已经开始关闭,则直接关闭监听器
+	s.listenerLock.Lock()
+	if s.isShuttingDown() {
+		s.listenerLock.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.udpListener = listener
+	s.acceptWg.Add(1)
+	s.listenerLock.Unlock()
+
+	go func() {
+		defer s.acceptWg.Done()
+		fmt.Println("start Zinx udp succ, Listening at ", listener.Addr())
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				//监听器已经被Shutdown关闭,退出Accept循环
+				if s.isShuttingDown() || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				fmt.Println("udp accept error: ", err)
+				continue
+			}
+			s.handleConn(conn)
+		}
+	}()
+	return nil
+}
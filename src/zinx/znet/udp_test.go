@@ -0,0 +1,228 @@
+package znet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//创建一个发往本地空闲端口的会话,只用来测试接收的逻辑
+func newTestUdpSession(t *testing.T) *udpSession {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := newUdpSession(1, conn, peer.LocalAddr().(*net.UDPAddr), false, nil)
+	t.Cleanup(func() { s.closeWithErr(ErrConnClosed, false) })
+	return s
+}
+
+//读出会话中已经交付的消息
+func recvUdpPayloads(s *udpSession) []string {
+	var payloads []string
+	for {
+		select {
+		case data := <-s.recvChan:
+			payloads = append(payloads, string(data))
+		default:
+			return payloads
+		}
+	}
+}
+
+func TestUdpSessionOrdering(t *testing.T) {
+	s := newTestUdpSession(t)
+
+	//可靠消息乱序或重复到达,按照seq的顺序只交付一次
+	s.input(udpFlagReliable, 2, 0, []byte("b"))
+	s.input(udpFlagReliable, 3, 0, []byte("c"))
+	s.input(udpFlagReliable, 1, 0, []byte("a"))
+	s.input(udpFlagReliable, 2, 0, []byte("b"))
+	//不可靠的消息丢弃比已交付的消息更旧的消息
+	s.input(0, 5, 0, []byte("x"))
+	s.input(0, 4, 0, []byte("y"))
+	s.input(0, 6, 0, []byte("z"))
+
+	got := recvUdpPayloads(s)
+	want := []string{"a", "b", "c", "x", "z"}
+	if len(got) != len(want) {
+		t.Fatalf("payloads = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("payloads = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestServerUDP(t *testing.T) {
+	oldPort, oldIdle, oldReliable := utils.GlobalObject.UdpPort, utils.GlobalObject.UdpIdleTimeout, utils.GlobalObject.UdpReliableMsgIDs
+	defer func() {
+		utils.GlobalObject.UdpPort = oldPort
+		utils.GlobalObject.UdpIdleTimeout = oldIdle
+		utils.GlobalObject.UdpReliableMsgIDs = oldReliable
+	}()
+	utils.GlobalObject.UdpPort = 7790
+	utils.GlobalObject.UdpIdleTimeout = 1
+	utils.GlobalObject.UdpReliableMsgIDs = []uint32{2}
+
+	s := newTestServer(t, 7789)
+	s.AddRouter(2, &echoRouter{})
+	closeErrs := make(chan *ziface.CloseError, 1)
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		closeErrs <- closeErr
+	})
+	s.Start()
+	defer s.Stop()
+
+	dp := NewDataPack()
+	conn, err := DialUDP("127.0.0.1:7790", dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, _ := dp.Pack(NewMsgPackage(2, []byte("hello udp")))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	msg, err := readMessage(bufio.NewReader(conn), dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetMsgId() != 2 || string(msg.GetData()) != "hello udp" {
+		t.Errorf("recv msgID = %d, data = %q", msg.GetMsgId(), msg.GetData())
+	}
+	if n := s.GetConnMgr().Len(); n != 1 {
+		t.Errorf("ConnMgr len = %d, want 1", n)
+	}
+
+	//客户端不再发送数据,会话过期之后连接以超时的原因关闭
+	select {
+	case closeErr := <-closeErrs:
+		if closeErr.Reason != ziface.CloseReasonTimeout {
+			t.Errorf("close reason = %v, want %v", closeErr.Reason, ziface.CloseReasonTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("udp session not expired")
+	}
+}
+
+//向UDP服务端发送一个数据报,返回服务端的回复,没有回复时ok为false
+func udpExchange(t *testing.T, sock *net.UDPConn, raddr net.Addr, flags byte, id uint32, payload []byte) (byte, uint32, []byte, bool) {
+	datagram := make([]byte, udpHeadLen+len(payload))
+	datagram[0] = flags
+	binary.LittleEndian.PutUint32(datagram[1:], id)
+	copy(datagram[udpHeadLen:], payload)
+	if _, err := sock.WriteTo(datagram, raddr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, udpMaxDatagram)
+	sock.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	n, _, err := sock.ReadFrom(buf)
+	if err != nil {
+		return 0, 0, nil, false
+	}
+	replyFlags, replyID, _, _, ok := parseUdpHead(buf[:n])
+	return replyFlags, replyID, buf[udpHeadLen:n], ok
+}
+
+//用原始的UDP套接字完成握手,返回服务端分配的会话ID
+func udpRawHandshake(t *testing.T, sock *net.UDPConn, raddr net.Addr, id uint32) (uint32, bool) {
+	flags, _, cookie, ok := udpExchange(t, sock, raddr, udpFlagHello, id, make([]byte, udpCookieLen))
+	if !ok || flags&udpFlagChallenge == 0 || len(cookie) != udpCookieLen {
+		return 0, false
+	}
+	flags, welcomeID, _, ok := udpExchange(t, sock, raddr, udpFlagHello, id, cookie)
+	if !ok || flags&udpFlagWelcome == 0 {
+		return 0, false
+	}
+	return welcomeID, true
+}
+
+func newRawUdpSocket(t *testing.T) *net.UDPConn {
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	return sock
+}
+
+//没有完成握手不会创建会话,知道会话ID的其他地址不能接管会话
+func TestUdpHandshake(t *testing.T) {
+	l, err := listenUDP("127.0.0.1:0", nil, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	raddr := l.Addr()
+
+	//没有握手的数据和没有填充的Hello都被忽略
+	attacker := newRawUdpSocket(t)
+	if _, _, _, ok := udpExchange(t, attacker, raddr, 0, 0, []byte("data")); ok {
+		t.Error("server replied to data without handshake")
+	}
+	if _, _, _, ok := udpExchange(t, attacker, raddr, udpFlagHello, 0, nil); ok {
+		t.Error("server replied to hello without padding")
+	}
+	//伪造的cookie只会收到新的Challenge
+	flags, _, _, _ := udpExchange(t, attacker, raddr, udpFlagHello, 0, make([]byte, udpCookieLen))
+	if flags&udpFlagChallenge == 0 {
+		t.Errorf("reply flags = %b, want challenge", flags)
+	}
+	l.sessionLock.Lock()
+	n := len(l.sessions)
+	l.sessionLock.Unlock()
+	if n != 0 {
+		t.Fatalf("sessions = %d before handshake, want 0", n)
+	}
+
+	//客户端完成握手之后才创建会话
+	client, err := DialUDP(raddr.String(), NewDataPack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var session net.Conn
+	select {
+	case session = <-l.acceptChan:
+	case <-time.After(time.Second):
+		t.Fatal("session not accepted after handshake")
+	}
+	id := atomic.LoadUint32(&client.(*udpSession).id)
+	clientAddr := session.RemoteAddr().String()
+
+	//知道会话ID的其他地址发送数据或者带着会话ID握手,都不会收到回复,会话的地址不变
+	if _, _, _, ok := udpExchange(t, attacker, raddr, 0, id, []byte("hijack")); ok {
+		t.Error("server replied to data from another address")
+	}
+	if _, ok := udpRawHandshake(t, attacker, raddr, id); ok {
+		t.Error("handshake with an existing session ID succeeded")
+	}
+	if got := session.RemoteAddr().String(); got != clientAddr {
+		t.Fatalf("session moved to %s", got)
+	}
+
+	//达到UdpMaxSessions之后不再建立新的会话
+	if _, ok := udpRawHandshake(t, newRawUdpSocket(t), raddr, 0); !ok {
+		t.Fatal("second session handshake failed")
+	}
+	if _, ok := udpRawHandshake(t, newRawUdpSocket(t), raddr, 0); ok {
+		t.Error("session created beyond max sessions")
+	}
+}
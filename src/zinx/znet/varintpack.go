@@ -0,0 +1,93 @@
+package znet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//变长包头的封包,拆包模块
+//|msgID(uvarint)|datalen(uvarint)|data
+//包头长度不固定,只能通过Decode从数据流中拆包
+type VarintDataPack struct {
+}
+
+//变长包头拆包封包实例的初始化方法
+func NewVarintDataPack() *VarintDataPack {
+	return &VarintDataPack{}
+}
+
+//包头的长度不固定,返回0
+func (dp *VarintDataPack) GetHeadLen() uint32 {
+	return 0
+}
+
+//封包方法
+func (dp *VarintDataPack) Pack(msg ziface.IMessage) ([]byte, error) {
+	buf := make([]byte, 2*binary.MaxVarintLen32+len(msg.GetData()))
+
+	n := binary.PutUvarint(buf, uint64(msg.GetMsgId()))
+	n += binary.PutUvarint(buf[n:], uint64(msg.GetMsgLen()))
+	n += copy(buf[n:], msg.GetData())
+
+	return buf[:n], nil
+}
+
+//拆包方法,从binaryData的开头解析出msgID和datalen
+func (dp *VarintDataPack) Unpack(binaryData []byte) (ziface.IMessage, error) {
+	id, n := binary.Uvarint(binaryData)
+	if n <= 0 {
+		return nil, errors.New("invalid varint msg id")
+	}
+	dataLen, m := binary.Uvarint(binaryData[n:])
+	if m <= 0 {
+		return nil, errors.New("invalid varint msg data len")
+	}
+
+	return dp.newMessage(id, dataLen)
+}
+
+//流式拆包方法
+func (dp *VarintDataPack) Decode(r *bufio.Reader) (ziface.IMessage, error) {
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	dataLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := dp.newMessage(id, dataLen)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.GetMsgLen() > 0 {
+		data := make([]byte, msg.GetMsgLen())
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		msg.SetData(data)
+	}
+
+	return msg, nil
+}
+
+//根据解析出的包头创建消息,并判断是否超出了允许的最大包长度
+func (dp *VarintDataPack) newMessage(id, dataLen uint64) (ziface.IMessage, error) {
+	if id > 0xFFFFFFFF || dataLen > 0xFFFFFFFF {
+		return nil, errors.New("varint msg head overflow")
+	}
+	if utils.GlobalObject.MaxPackageSize > 0 && dataLen > uint64(utils.GlobalObject.MaxPackageSize) {
+		return nil, errors.New("too Large msg data recv!")
+	}
+
+	return &Message{
+		Id:      uint32(id),
+		DataLen: uint32(dataLen),
+	}, nil
+}
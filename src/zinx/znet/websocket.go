@@ -0,0 +1,442 @@
+package znet
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/utils"
+)
+
+//WebSocket协议相关的常量(RFC 6455)
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal      = 1000
+	wsCloseUnsupported = 1003
+
+	//控制帧的最大长度
+	wsMaxControlPayload = 125
+	//关闭WebSocket连接时,发送关闭帧的最长时间
+	wsCloseTimeout = time.Second
+	//Reader回复Pong和关闭帧的最长时间
+	wsControlTimeout = time.Second
+)
+
+var (
+	//WebSocket帧不符合协议
+	ErrWsProtocol = errors.New("websocket protocol error")
+	//收到了不支持的文本帧,WebSocket连接只支持二进制帧
+	ErrWsTextFrame = errors.New("websocket text frame is not supported")
+)
+
+//将WebSocket连接包装为net.Conn
+//读取时将所有二进制帧的内容拼接为连续的数据流,交给IDataPack拆包
+//写入时每次Write发送一个二进制帧,Writer每次写入一个完整的消息,所以一个消息对应一个帧
+type wsConn struct {
+	//底层的TCP或者TLS连接
+	conn net.Conn
+	//带缓冲的读取底层连接
+	reader *bufio.Reader
+	//客户端发送的帧需要掩码,服务端发送的帧不需要
+	isClient bool
+	//HTTPS升级的连接的TLS状态
+	tlsState *tls.ConnectionState
+
+	//当前正在读取的数据帧还剩余的长度
+	remaining uint64
+	//当前正在读取的数据帧的掩码
+	mask [4]byte
+	//当前数据帧的掩码位置
+	maskPos int
+	//当前数据帧是否有掩码
+	masked bool
+
+	//保护写操作,Writer和回复Ping/Close的Reader可能同时写
+	writeLock sync.Mutex
+	//Connection设置的写截止时间(time.Time),Reader写完控制帧之后恢复,控制帧不受其影响
+	writeDeadline atomic.Value
+	//保证Close只执行一次
+	closeOnce sync.Once
+}
+
+//创建一个WebSocket连接,reader为握手之后底层连接的带缓冲读取器
+func newWsConn(conn net.Conn, reader *bufio.Reader, isClient bool) *wsConn {
+	return &wsConn{
+		conn:     conn,
+		reader:   reader,
+		isClient: isClient,
+	}
+}
+
+//读取二进制帧中的数据,遇到控制帧时直接处理
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextDataFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+//读取帧头,直到遇到一个数据帧
+func (c *wsConn) nextDataFrame() error {
+	for {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, head); err != nil {
+			return err
+		}
+		opcode := head[0] & 0x0F
+		masked := head[1]&0x80 != 0
+		length := uint64(head[1] & 0x7F)
+
+		//服务端收到的帧必须有掩码,客户端收到的帧不能有掩码
+		if head[0]&0x70 != 0 || masked == c.isClient {
+			return ErrWsProtocol
+		}
+
+		switch length {
+		case 126:
+			ext := make([]byte, 2)
+			if _, err := io.ReadFull(c.reader, ext); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext))
+		case 127:
+			ext := make([]byte, 8)
+			if _, err := io.ReadFull(c.reader, ext); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext)
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+				return err
+			}
+		}
+
+		switch opcode {
+		case wsOpBinary, wsOpContinuation:
+			c.remaining, c.mask, c.maskPos, c.masked = length, mask, 0, masked
+			if length > 0 {
+				return nil
+			}
+		case wsOpText:
+			c.writeClose(wsCloseUnsupported)
+			return ErrWsTextFrame
+		case wsOpClose, wsOpPing, wsOpPong:
+			if length > wsMaxControlPayload {
+				return ErrWsProtocol
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.reader, payload); err != nil {
+				return err
+			}
+			if masked {
+				for i := range payload {
+					payload[i] ^= mask[i&3]
+				}
+			}
+			if opcode == wsOpClose {
+				//对端关闭了WebSocket连接,回复关闭帧
+				c.writeClose(wsCloseNormal)
+				return io.EOF
+			}
+			if opcode == wsOpPing {
+				if err := c.writeControl(wsOpPong, payload); err != nil {
+					return err
+				}
+			}
+		default:
+			return ErrWsProtocol
+		}
+	}
+}
+
+//每次Write发送一个二进制帧
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//发送一个完整的帧
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame, err := c.frame(opcode, payload)
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+//发送一个控制帧,使用单独的写截止时间
+//Connection的写截止时间是在写上一个消息之前设置的,Reader回复Ping时它可能早已过期
+func (c *wsConn) writeControl(opcode byte, payload []byte) error {
+	frame, err := c.frame(opcode, payload)
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsControlTimeout))
+	_, err = c.conn.Write(frame)
+	deadline, _ := c.writeDeadline.Load().(time.Time)
+	c.conn.SetWriteDeadline(deadline)
+	return err
+}
+
+//把payload编码为一个完整的帧
+func (c *wsConn) frame(opcode byte, payload []byte) ([]byte, error) {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.isClient {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	if c.isClient {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return nil, err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i&3]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	return frame, nil
+}
+
+//发送关闭帧
+func (c *wsConn) writeClose(code uint16) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	c.writeControl(wsOpClose, payload)
+}
+
+//关闭WebSocket连接,尽量先发送关闭帧
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		//写截止时间同样会让阻塞中的Write返回,避免一直拿不到写锁
+		c.conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+		c.writeClose(wsCloseNormal)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return c.conn.SetDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return c.conn.SetWriteDeadline(t)
+}
+
+//HTTPS升级的连接返回TLS状态
+func (c *wsConn) tlsConnectionState() *tls.ConnectionState {
+	return c.tlsState
+}
+
+//计算握手时回复的Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+//判断HTTP头中是否包含指定的token(不区分大小写)
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//将HTTP请求升级为WebSocket连接
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("http.ResponseWriter is not a Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	//清除http.Server读取请求时设置的超时,之后由Connection管理
+	conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ws := newWsConn(conn, rw.Reader, false)
+	ws.tlsState = r.TLS
+	return ws, nil
+}
+
+//没有设置ReadTimeout时,读取WebSocket握手请求头的最长时间
+const wsHeaderTimeout = 10 * time.Second
+
+//开启WebSocket服务,升级之后的连接和TCP连接一样交给handleConn处理
+func (s *Server) startWebSocket() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.IP, strconv.Itoa(utils.GlobalObject.WsPort)))
+	if err != nil {
+		return err
+	}
+	//开启了TLS时,WebSocket同样使用TLS(wss)
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(utils.GlobalObject.WsPath, s.serveWebSocket)
+	//限制读取握手请求头的时间,避免只发送部分请求头的客户端一直占用连接
+	headerTimeout := wsHeaderTimeout
+	if utils.GlobalObject.ReadTimeout > 0 {
+		headerTimeout = time.Duration(utils.GlobalObject.ReadTimeout) * time.Second
+	}
+	wsServer := &http.Server{Handler: mux, ReadHeaderTimeout: headerTimeout}
+
+	//如果在监听的过程中Server已经开始关闭,则直接关闭监听器
+	s.listenerLock.Lock()
+	if s.isShuttingDown() {
+		s.listenerLock.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.wsServer = wsServer
+	s.acceptWg.Add(1)
+	s.listenerLock.Unlock()
+
+	go func() {
+		defer s.acceptWg.Done()
+		fmt.Println("start Zinx websocket succ, Listening at ", listener.Addr(), utils.GlobalObject.WsPath)
+		if err := wsServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Println("websocket serve err: ", err)
+		}
+	}()
+	return nil
+}
+
+//处理WebSocket的握手请求
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		fmt.Println("websocket upgrade from ", r.RemoteAddr, " err: ", err)
+		return
+	}
+
+	//Server已经开始关闭时不再接收新的连接,否则在Shutdown等待Accept结束之前把连接加入Server
+	s.listenerLock.Lock()
+	if s.isShuttingDown() {
+		s.listenerLock.Unlock()
+		ws.Close()
+		return
+	}
+	s.acceptWg.Add(1)
+	s.listenerLock.Unlock()
+	defer s.acceptWg.Done()
+
+	s.handleConn(ws)
+}
@@ -0,0 +1,195 @@
+package znet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//测试用的WebSocket客户端,完成握手之后返回客户端的wsConn
+func dialWebSocket(t *testing.T, port int, path string) *wsConn {
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	request := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: 127.0.0.1\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return newWsConn(conn, reader, true)
+}
+
+//TCP和WebSocket的客户端使用同一个Server,同一套路由和连接管理
+func TestServerWebSocket(t *testing.T) {
+	oldPort, oldPath := utils.GlobalObject.WsPort, utils.GlobalObject.WsPath
+	defer func() {
+		utils.GlobalObject.WsPort = oldPort
+		utils.GlobalObject.WsPath = oldPath
+	}()
+	utils.GlobalObject.WsPort = 7788
+	utils.GlobalObject.WsPath = "/ws"
+
+	s := newTestServer(t, 7787)
+	s.AddRouter(7, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+
+	tcpClient := dialTestServer(t, 7787)
+	defer tcpClient.Close()
+	wsClient := dialWebSocket(t, 7788, "/ws")
+	defer wsClient.Close()
+
+	dp := NewDataPack()
+	for name, conn := range map[string]net.Conn{"tcp": tcpClient, "websocket": wsClient} {
+		//分两次发送一个消息,服务端需要把多个帧拼接起来拆包
+		data, _ := dp.Pack(NewMsgPackage(7, []byte("hello "+name)))
+		if _, err := conn.Write(data[:3]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(data[3:]); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		msg, err := readMessage(bufio.NewReader(conn), dp)
+		if err != nil {
+			t.Fatalf("%s read err: %v", name, err)
+		}
+		if msg.GetMsgId() != 7 || string(msg.GetData()) != "hello "+name {
+			t.Errorf("%s recv msgID = %d, data = %q", name, msg.GetMsgId(), msg.GetData())
+		}
+	}
+
+	if n := s.GetConnMgr().Len(); n != 2 {
+		t.Errorf("ConnMgr len = %d, want 2", n)
+	}
+
+	//Ping帧由WebSocket层直接回复Pong,不会交给路由
+	if err := wsClient.writeFrame(wsOpPing, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsClient.writeFrame(wsOpText, []byte("text")); err != nil {
+		t.Fatal(err)
+	}
+	//收到文本帧之后服务端关闭连接
+	wsClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := wsClient.Read(make([]byte, 1)); err == nil {
+		t.Error("websocket connection not closed after text frame")
+	}
+}
+
+//只发送部分握手请求头的客户端在ReadTimeout之后被断开
+func TestServerWebSocketHeaderTimeout(t *testing.T) {
+	oldPort, oldPath, oldTimeout := utils.GlobalObject.WsPort, utils.GlobalObject.WsPath, utils.GlobalObject.ReadTimeout
+	defer func() {
+		utils.GlobalObject.WsPort = oldPort
+		utils.GlobalObject.WsPath = oldPath
+		utils.GlobalObject.ReadTimeout = oldTimeout
+	}()
+	utils.GlobalObject.WsPort = 7799
+	utils.GlobalObject.WsPath = "/ws"
+	utils.GlobalObject.ReadTimeout = 1
+
+	s := newTestServer(t, 7798)
+	s.Start()
+	defer s.Stop()
+
+	conn := dialTestServer(t, 7799)
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: 127.0.0.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	//服务端没有回复就关闭了连接
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1)
+	if n, err := conn.Read(buf); n != 0 || err == nil || isTimeout(err) {
+		t.Fatalf("read = %d, %v, want closed by server", n, err)
+	}
+}
+
+//写消息时设置的写截止时间过期之后,客户端的Ping不能让连接因为写超时被关闭
+func TestServerWebSocketPingAfterWrite(t *testing.T) {
+	oldPort, oldPath, oldTimeout := utils.GlobalObject.WsPort, utils.GlobalObject.WsPath, utils.GlobalObject.WriteTimeout
+	defer func() {
+		utils.GlobalObject.WsPort = oldPort
+		utils.GlobalObject.WsPath = oldPath
+		utils.GlobalObject.WriteTimeout = oldTimeout
+	}()
+	utils.GlobalObject.WsPort = 7802
+	utils.GlobalObject.WsPath = "/ws"
+	utils.GlobalObject.WriteTimeout = 1
+
+	s := newTestServer(t, 7801)
+	s.AddRouter(7, &echoRouter{})
+	stopped := make(chan *ziface.CloseError, 1)
+	s.SetOnConnStop(func(conn ziface.IConnection, closeErr *ziface.CloseError) {
+		stopped <- closeErr
+	})
+	s.Start()
+	defer s.Stop()
+
+	wsClient := dialWebSocket(t, 7802, "/ws")
+	defer wsClient.Close()
+	reader := bufio.NewReader(wsClient)
+	dp := NewDataPack()
+	echo := func(data string) {
+		msg, _ := dp.Pack(NewMsgPackage(7, []byte(data)))
+		if _, err := wsClient.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		wsClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reply, err := readMessage(reader, dp)
+		if err != nil {
+			t.Fatalf("read %s reply err: %v", data, err)
+		}
+		if string(reply.GetData()) != data {
+			t.Errorf("reply data = %q, want %q", reply.GetData(), data)
+		}
+	}
+
+	//服务端写完一个消息之后,等待写截止时间过期再发送Ping
+	echo("before ping")
+	time.Sleep(1500 * time.Millisecond)
+	if err := wsClient.writeFrame(wsOpPing, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	echo("after ping")
+
+	select {
+	case closeErr := <-stopped:
+		t.Fatalf("connection closed after ping: %v", closeErr)
+	default:
+	}
+}
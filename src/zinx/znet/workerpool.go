@@ -0,0 +1,207 @@
+package znet
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+	"zinx/utils"
+)
+
+//弹性Worker池中一个Worker连续处理同一个TaskQueue的最大请求数,之后让出给其他TaskQueue
+const workerBatchSize = 64
+
+//弹性Worker池
+//TaskQueue的个数固定为WorkerPoolSize,分配策略和连接的亲和性不受Worker数量变化的影响;
+//Worker Goroutine不再绑定TaskQueue,而是从readyQueue中领取有请求的TaskQueue进行处理,
+//同一时刻一个TaskQueue最多只被一个Worker处理,保证同一个TaskQueue中的请求仍然按顺序执行。
+//放入请求时,等待处理的TaskQueue多于空闲的Worker就增加Worker,不单独统计处理耗时:
+//处理耗时变长时Worker一直忙碌,新的请求到来时就会出现积压。空闲超过WorkerIdleTimeout的Worker退出
+type elasticPool struct {
+	//等待Worker处理的TaskQueue的ID,每个TaskQueue同一时刻最多在其中出现一次
+	readyQueue chan uint32
+	//每个TaskQueue是否已经放入readyQueue或者正在被Worker处理,只能通过atomic读写
+	scheduled []int32
+	//Worker数量的上下限
+	minWorkers int32
+	maxWorkers int32
+	//当前的Worker数量和其中空闲的Worker数量,只能通过atomic读写
+	workers     int32
+	idleWorkers int32
+	//停止Worker池时关闭
+	exitChan chan struct{}
+}
+
+//是否开启了弹性Worker池
+func elasticEnabled() bool {
+	return utils.GlobalObject.WorkerPoolMaxSize > 0
+}
+
+//启动弹性Worker池,先启动WorkerPoolMinSize个Worker
+func (mh *MsgHandle) startElasticPool() {
+	maxWorkers := utils.GlobalObject.WorkerPoolMaxSize
+	//同一时刻一个TaskQueue最多只被一个Worker处理,多于TaskQueue个数的Worker永远不会有事可做
+	if maxWorkers > mh.WorkerPoolSize {
+		fmt.Println("[Zinx] WorkerPoolMaxSize = ", maxWorkers, " is larger than WorkerPoolSize, use WorkerPoolSize = ", mh.WorkerPoolSize)
+		maxWorkers = mh.WorkerPoolSize
+	}
+	minWorkers := utils.GlobalObject.WorkerPoolMinSize
+	if minWorkers == 0 {
+		minWorkers = 1
+	}
+	if minWorkers > maxWorkers {
+		fmt.Println("[Zinx] WorkerPoolMinSize = ", minWorkers, " is larger than the max worker num, use ", maxWorkers)
+		minWorkers = maxWorkers
+	}
+
+	mh.pool = &elasticPool{
+		readyQueue: make(chan uint32, mh.WorkerPoolSize),
+		scheduled:  make([]int32, mh.WorkerPoolSize),
+		minWorkers: int32(minWorkers),
+		maxWorkers: int32(maxWorkers),
+		exitChan:   make(chan struct{}),
+	}
+	for i := uint32(0); i < minWorkers; i++ {
+		mh.spawnWorker()
+	}
+}
+
+//停止弹性Worker池,等待所有Worker退出之后处理掉TaskQueue中剩余的请求
+func (mh *MsgHandle) stopElasticPool() {
+	close(mh.pool.exitChan)
+	mh.workerWg.Wait()
+
+	//Worker退出前可能还有TaskQueue刚被放回readyQueue,此时已经没有Worker,按TaskQueue依次处理
+	for workerID := uint32(0); workerID < mh.WorkerPoolSize; workerID++ {
+		for {
+			request, ok := mh.nextRequest(workerID)
+			if !ok {
+				break
+			}
+			mh.DoMsgHandler(request)
+			atomic.AddUint64(&mh.workerCounters[workerID].processed, 1)
+		}
+	}
+}
+
+//TaskQueue中放入了请求,如果该TaskQueue没有在等待或者正在被处理,把它交给Worker
+func (mh *MsgHandle) schedule(workerID uint32) {
+	p := mh.pool
+	if !atomic.CompareAndSwapInt32(&p.scheduled[workerID], 0, 1) {
+		return
+	}
+	//每个TaskQueue最多在readyQueue中出现一次,不会阻塞
+	p.readyQueue <- workerID
+
+	//等待处理的TaskQueue多于空闲的Worker时增加一个Worker
+	if int32(len(p.readyQueue)) > atomic.LoadInt32(&p.idleWorkers) {
+		mh.spawnWorker()
+	}
+}
+
+//Worker数量没有达到上限时启动一个新的Worker
+func (mh *MsgHandle) spawnWorker() {
+	p := mh.pool
+	for {
+		n := atomic.LoadInt32(&p.workers)
+		if n >= p.maxWorkers {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&p.workers, n, n+1) {
+			fmt.Println("Elastic worker is started, worker num = ", n+1)
+			mh.workerWg.Add(1)
+			go mh.startElasticWorker()
+			return
+		}
+	}
+}
+
+//Worker数量多于下限时减少一个Worker,返回当前Worker是否可以退出
+func (mh *MsgHandle) retireWorker() bool {
+	p := mh.pool
+	for {
+		n := atomic.LoadInt32(&p.workers)
+		if n <= p.minWorkers {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.workers, n, n-1) {
+			fmt.Println("Elastic worker is stopped, worker num = ", n-1)
+			return true
+		}
+	}
+}
+
+//弹性Worker池中一个Worker的工作流程
+func (mh *MsgHandle) startElasticWorker() {
+	defer mh.workerWg.Done()
+	p := mh.pool
+
+	idleTimeout := time.Duration(utils.GlobalObject.WorkerIdleTimeout) * time.Millisecond
+	if idleTimeout <= 0 {
+		idleTimeout = time.Duration(1<<63 - 1)
+	}
+	idleTimer := time.NewTimer(idleTimeout)
+	defer idleTimer.Stop()
+
+	for {
+		atomic.AddInt32(&p.idleWorkers, 1)
+		select {
+		case workerID := <-p.readyQueue:
+			atomic.AddInt32(&p.idleWorkers, -1)
+			mh.runTaskQueue(workerID)
+			if !idleTimer.Stop() {
+				select {
+				case <-idleTimer.C:
+				default:
+				}
+			}
+			idleTimer.Reset(idleTimeout)
+		case <-idleTimer.C:
+			atomic.AddInt32(&p.idleWorkers, -1)
+			if mh.retireWorker() {
+				return
+			}
+			idleTimer.Reset(idleTimeout)
+		case <-p.exitChan:
+			atomic.AddInt32(&p.idleWorkers, -1)
+			//处理完readyQueue中剩余的TaskQueue之后退出
+			for {
+				select {
+				case workerID := <-p.readyQueue:
+					mh.runTaskQueue(workerID)
+				default:
+					atomic.AddInt32(&p.workers, -1)
+					return
+				}
+			}
+		}
+	}
+}
+
+//处理一个TaskQueue中的请求,最多处理workerBatchSize个,剩余的请求重新放回readyQueue
+func (mh *MsgHandle) runTaskQueue(workerID uint32) {
+	p := mh.pool
+	counter := &mh.workerCounters[workerID]
+
+	for i := 0; i < workerBatchSize; i++ {
+		request, ok := mh.nextRequest(workerID)
+		if !ok {
+			break
+		}
+		mh.DoMsgHandler(request)
+		atomic.AddUint64(&counter.processed, 1)
+	}
+
+	//先释放TaskQueue再检查是否还有请求,避免Reader在释放之前放入的请求没有Worker处理
+	atomic.StoreInt32(&p.scheduled[workerID], 0)
+	if mh.laneLen(workerID) > 0 {
+		mh.schedule(workerID)
+	}
+}
+
+//获取当前运行的Worker Goroutine数量
+func (mh *MsgHandle) GetWorkerNum() int {
+	if mh.pool == nil {
+		return int(mh.WorkerPoolSize)
+	}
+	return int(atomic.LoadInt32(&mh.pool.workers))
+}
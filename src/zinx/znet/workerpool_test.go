@@ -0,0 +1,118 @@
+package znet
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+//等待cond成立,超时返回false
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestElasticWorkerPool(t *testing.T) {
+	defer func(size, minSize, maxSize uint32, idle int) {
+		utils.GlobalObject.WorkerPoolSize = size
+		utils.GlobalObject.WorkerPoolMinSize = minSize
+		utils.GlobalObject.WorkerPoolMaxSize = maxSize
+		utils.GlobalObject.WorkerIdleTimeout = idle
+	}(utils.GlobalObject.WorkerPoolSize, utils.GlobalObject.WorkerPoolMinSize,
+		utils.GlobalObject.WorkerPoolMaxSize, utils.GlobalObject.WorkerIdleTimeout)
+	utils.GlobalObject.WorkerPoolSize = 8
+	utils.GlobalObject.WorkerPoolMinSize = 1
+	utils.GlobalObject.WorkerPoolMaxSize = 4
+	utils.GlobalObject.WorkerIdleTimeout = 50
+
+	s := NewServer("[zinx test]").(*Server)
+	mh := s.MsgHandler.(*MsgHandle)
+
+	//每个连接收到的消息序号,同一个TaskQueue的请求不能被并发处理
+	var lock sync.Mutex
+	seqs := make(map[uint32][]int)
+	release := make(chan struct{})
+	mh.AddHandlerFunc(1, func(request ziface.IRequest) {
+		<-release
+		lock.Lock()
+		connID := request.GetConnection().GetConnID()
+		seqs[connID] = append(seqs[connID], int(request.GetData()[0]))
+		lock.Unlock()
+	})
+	mh.StartWorkerPool()
+	if n := mh.GetWorkerNum(); n != 1 {
+		t.Fatalf("worker num after start = %d, want 1", n)
+	}
+
+	send := func(from, to int) {
+		for connID := uint32(0); connID < 8; connID++ {
+			conn := NewConnection(s, nil, connID, mh)
+			for i := from; i < to; i++ {
+				mh.SendMsgToTaskQueue(&Request{conn: conn, msg: NewMsgPackage(1, []byte{byte(i)})})
+			}
+		}
+	}
+
+	//处理阻塞,队列积压时Worker增加到WorkerPoolMaxSize
+	send(0, 10)
+	if !waitFor(func() bool { return mh.GetWorkerNum() == 4 }) {
+		t.Fatalf("worker num under load = %d, want 4", mh.GetWorkerNum())
+	}
+
+	//请求处理完之后空闲的Worker退出,保留WorkerPoolMinSize个
+	close(release)
+	if !waitFor(func() bool { return mh.GetWorkerNum() == 1 }) {
+		t.Fatalf("worker num when idle = %d, want 1", mh.GetWorkerNum())
+	}
+
+	send(10, 20)
+	mh.StopWorkerPool()
+
+	for connID := uint32(0); connID < 8; connID++ {
+		got := seqs[connID]
+		if len(got) != 20 {
+			t.Fatalf("connID = %d handled %d requests, want 20", connID, len(got))
+		}
+		for i, seq := range got {
+			if seq != i {
+				t.Fatalf("connID = %d handled out of order: %v", connID, got)
+			}
+		}
+	}
+	var processed uint64
+	for _, stats := range mh.GetWorkerStats() {
+		processed += stats.Processed
+	}
+	if processed != 160 {
+		t.Errorf("processed = %d, want 160", processed)
+	}
+}
+
+//WorkerPoolMaxSize大于WorkerPoolSize时,Worker数量的上限是TaskQueue的个数
+func TestElasticWorkerPoolMaxSize(t *testing.T) {
+	defer func(size, minSize, maxSize uint32) {
+		utils.GlobalObject.WorkerPoolSize = size
+		utils.GlobalObject.WorkerPoolMinSize = minSize
+		utils.GlobalObject.WorkerPoolMaxSize = maxSize
+	}(utils.GlobalObject.WorkerPoolSize, utils.GlobalObject.WorkerPoolMinSize, utils.GlobalObject.WorkerPoolMaxSize)
+	utils.GlobalObject.WorkerPoolSize = 2
+	utils.GlobalObject.WorkerPoolMinSize = 4
+	utils.GlobalObject.WorkerPoolMaxSize = 8
+
+	mh := NewServer("[zinx test]").(*Server).MsgHandler.(*MsgHandle)
+	mh.StartWorkerPool()
+	defer mh.StopWorkerPool()
+	if mh.pool.maxWorkers != 2 || mh.pool.minWorkers != 2 {
+		t.Errorf("min/max workers = %d/%d, want 2/2", mh.pool.minWorkers, mh.pool.maxWorkers)
+	}
+	if n := mh.GetWorkerNum(); n != 2 {
+		t.Errorf("worker num = %d, want 2", n)
+	}
+}